@@ -2,7 +2,14 @@ package tagflag
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type arg struct {
@@ -10,18 +17,478 @@ type arg struct {
 	name  string
 	help  string
 	value reflect.Value
+	// Whether the arg is positional, rather than a flag.
+	positional bool
+	// Consumes all remaining arguments as a single space-joined value. Only valid for flags.
+	rest bool
+	// If not empty, the only values permitted.
+	choices []string
+	// The usage group of a flag, or empty for the main options.
+	group string
+	// An alternative name for a flag, if it has one.
+	short string
+	// If not empty, the flag shares the field of the named flag, and sets it in another way.
+	derivedFrom string
+	// Sets a bool field to the opposite of the value given.
+	inverse bool
+	// If not zero, the flag takes no value, and adds this to an integer field each time it occurs.
+	step int64
+	// Bounds on a numeric value from the min and max tags. Invalid if there's no bound.
+	min, max reflect.Value
+	// Bounds on the length of a string value from the minlen and maxlen tags. maxLen only applies
+	// if hasMaxLen.
+	minLen, maxLen int
+	hasMaxLen      bool
+	// Writes warnings for the user, such as when a value is clamped. May be nil.
+	warnf func(format string, a ...interface{})
+	// Nil struct pointers enclosing the field, outermost first, to be assigned when it's set.
+	lazyPtrs []lazyPtr
+	// The directory that relative paths are resolved against, for args with the path tag.
+	basePath string
+	tag      reflect.StructTag
+}
+
+// Returns whether the arg was added by Parser.Func, and has no field.
+func (me arg) isFunc() bool {
+	return me.value.Type() == reflect.TypeOf(funcValue{})
+}
+
+// Returns the name of the arg's type, as shown in usage. Flags added by Parser.Func take strings.
+func (me arg) typeName() string {
+	if me.isFunc() {
+		return "string"
+	}
+	return me.value.Type().String()
 }
 
 func (me arg) hasZeroValue() bool {
+	if me.isFunc() {
+		return true
+	}
 	return reflect.DeepEqual(
 		reflect.Zero(me.value.Type()).Interface(),
 		me.value.Interface())
 }
 
+// Returns the marshaler for the arg's value, taking into account any tags that modify how the
+// value is parsed.
+func (me arg) marshaler() marshaler {
+	t := me.value.Type()
+	if t == reflect.TypeOf(net.IP(nil)) && me.tag.Get("resolve") == "true" {
+		return resolvingIPMarshaler
+	}
+	if t == reflect.TypeOf((*url.URL)(nil)) && me.tag.Get("normalize") == "true" {
+		return normalizingURLMarshaler
+	}
+	if me.tag.Get("ranges") == "true" && isIntegerSlice(t) {
+		return dynamicMarshaler{marshal: marshalRanges, explicitValueRequired: true}
+	}
+	switch me.tag.Get("encoding") {
+	case "json":
+		return jsonMarshaler{}
+	case "hex":
+		if isByteSlice(t) {
+			return hexBytesMarshaler
+		}
+	}
+	if sep := me.tag.Get("mapsep"); sep != "" && t.Kind() == reflect.Map {
+		return dynamicMarshaler{
+			marshal: func(v reflect.Value, s string) error {
+				return marshalMapEntrySep(v, s, sep)
+			},
+			explicitValueRequired: true,
+		}
+	}
+	if format := me.tag.Get("format"); format != "" && t == reflect.TypeOf(time.Time{}) {
+		return timeFormatMarshaler(format)
+	}
+	return valueMarshaler(t)
+}
+
+// Experimental flags are rejected unless enabled through the environment.
+func (me arg) isExperimental() bool {
+	return me.tag.Get("experimental") == "true"
+}
+
+// Returns whether the flag can take its value from the following argument, when given in the long
+// form. Bools, and flags with their own handling of following arguments, don't.
+func (me arg) takesSeparateValue() bool {
+	return me.arity.max != 0 && !me.rest && me.tag.Get("nargs") == "" && !isBoolType(me.value.Type())
+}
+
+// Returns whether each value replaces the last, rather than being accumulated, such as for slices,
+// maps and count flags.
+func (me arg) holdsSingleValue() bool {
+	if me.arity.max == 0 {
+		return false
+	}
+	t := me.value.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return isByteSlice(t)
+	}
+	return true
+}
+
+// Secret args have their values redacted wherever they're displayed, including in errors for
+// values from the environment.
+func (me arg) isSecret() bool {
+	return me.tag.Get("secret") == "true"
+}
+
+// Required flags must be set by the arguments, or a Source.
+func (me arg) isRequired() bool {
+	return me.tag.Get("required") == "true"
+}
+
+// Returns the name of the flag that a deprecated flag assigns instead, if any.
+func (me arg) replacedBy() string {
+	if me.tag.Get("deprecated") != "true" {
+		return ""
+	}
+	return me.tag.Get("replacedby")
+}
+
+// Path args have relative values resolved to absolute paths.
+func (me arg) isPath() bool {
+	return me.tag.Get("path") == "true"
+}
+
+// Returns an error if the arg has the ranges tag, but isn't a slice of integers.
+func (me arg) checkRangesTag() error {
+	if me.tag.Get("ranges") == "true" && !isIntegerSlice(me.value.Type()) {
+		return fmt.Errorf("%q has ranges tag, but %s is not a slice of integers", me.name, me.value.Type())
+	}
+	return nil
+}
+
+// Returns an error if the arg has the path tag, but doesn't hold strings.
+func (me arg) checkPathTag() error {
+	if !me.isPath() {
+		return nil
+	}
+	t := me.value.Type()
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.String {
+		return fmt.Errorf("%q has path tag, but %s is not a string", me.name, me.value.Type())
+	}
+	return nil
+}
+
+// Returns the cleaned absolute form of the path s, resolving it against the base path if it's
+// relative.
+func (me arg) resolvePath(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	if filepath.IsAbs(s) {
+		return filepath.Clean(s), nil
+	}
+	return filepath.Abs(filepath.Join(me.basePath, s))
+}
+
+// Returns the names of the flags in the seealso tag.
+func (me arg) seeAlso() []string {
+	if s := me.tag.Get("seealso"); s != "" {
+		return strings.Split(s, ",")
+	}
+	return nil
+}
+
+// Returns the value of the default tag, if there is one.
+func (me arg) defaultValue() (string, bool) {
+	return me.tag.Lookup("default")
+}
+
 func (me arg) marshal(s string, explicitValue bool) error {
-	m := valueMarshaler(me.value.Type())
+	m := me.marshaler()
 	if m.RequiresExplicitValue() && !explicitValue {
 		return userError{fmt.Sprintf("explicit value required (%s%s=VALUE)", flagPrefix, me.name)}
 	}
+	if !explicitValue && isBoolType(me.value.Type()) {
+		s = "true"
+	}
+	if me.positional && s == "" && isBoolType(me.value.Type()) {
+		// An empty flag value means true, but an empty positional argument is likely a mistake.
+		return userError{fmt.Sprintf("argument %s: empty value for bool", me.name)}
+	}
+	var err error
+	if me.isPath() {
+		if s, err = me.resolvePath(s); err != nil {
+			return err
+		}
+	}
+	if s, err = me.checkChoice(s); err != nil {
+		return err
+	}
+	if err := me.marshalValue(m, s); err != nil {
+		return me.conversionError(s, err)
+	}
+	if err := me.checkBounds(); err != nil {
+		return err
+	}
+	if err := me.checkLength(); err != nil {
+		return err
+	}
+	if me.inverse {
+		me.value.SetBool(!me.value.Bool())
+	}
+	if me.tag.Get("unique") == "true" {
+		me.dropDuplicateElem()
+	}
+	return nil
+}
+
+// Marshals s into the value, preferring any marshaling specific to whether the arg is positional
+// or a flag.
+func (me arg) marshalValue(m marshaler, s string) error {
+	if me.value.CanAddr() {
+		ptr := me.value.Addr().Interface()
+		if pm, ok := ptr.(PositionalMarshaler); ok && me.positional {
+			return pm.MarshalPositional(s)
+		}
+		if fm, ok := ptr.(FlagMarshaler); ok && !me.positional {
+			return fm.MarshalFlag(s)
+		}
+	}
 	return m.Marshal(me.value, s)
 }
+
+// Parses the min and max tags into values of the arg's type, using its marshaler, so that bounds
+// are given the same way as values.
+func (me *arg) parseBounds() error {
+	for _, b := range []struct {
+		tag   string
+		bound *reflect.Value
+	}{
+		{"min", &me.min},
+		{"max", &me.max},
+	} {
+		s, ok := me.tag.Lookup(b.tag)
+		if !ok {
+			continue
+		}
+		if numericKind(me.value.Kind()) == reflect.Invalid {
+			return fmt.Errorf("%q has %s tag, but %s is not numeric", me.name, b.tag, me.value.Type())
+		}
+		bound := *me
+		bound.value = reflect.New(me.value.Type()).Elem()
+		if err := bound.marshalValue(me.marshaler(), s); err != nil {
+			return fmt.Errorf("parsing %s tag %q for %q: %w", b.tag, s, me.name, err)
+		}
+		*b.bound = bound.value
+	}
+	return nil
+}
+
+// Parses the minlen and maxlen tags, which are only valid for strings.
+func (me *arg) parseLengthBounds() error {
+	for _, b := range []struct {
+		tag   string
+		bound *int
+	}{
+		{"minlen", &me.minLen},
+		{"maxlen", &me.maxLen},
+	} {
+		s, ok := me.tag.Lookup(b.tag)
+		if !ok {
+			continue
+		}
+		if t := me.value.Type(); t.Kind() != reflect.String && !(t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.String) {
+			return fmt.Errorf("%q has %s tag, but %s is not a string", me.name, b.tag, t)
+		}
+		n, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			return fmt.Errorf("parsing %s tag %q for %q: %w", b.tag, s, me.name, err)
+		}
+		*b.bound = int(n)
+	}
+	_, me.hasMaxLen = me.tag.Lookup("maxlen")
+	return nil
+}
+
+// Returns an error if the value is a string with a length outside the bounds from the minlen and
+// maxlen tags. The length is in characters.
+func (me arg) checkLength() error {
+	if me.minLen == 0 && !me.hasMaxLen {
+		return nil
+	}
+	v := me.value
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	n := utf8.RuneCountInString(v.String())
+	if n < me.minLen {
+		return userError{fmt.Sprintf("value has length %d, less than minimum %d", n, me.minLen)}
+	}
+	if me.hasMaxLen && n > me.maxLen {
+		return userError{fmt.Sprintf("value has length %d, greater than maximum %d", n, me.maxLen)}
+	}
+	return nil
+}
+
+// Returns an error if the value is outside the bounds from the min and max tags, or with the clamp
+// tag, sets it to the nearest bound.
+func (me arg) checkBounds() error {
+	if me.min.IsValid() && compareNumeric(me.value, me.min) < 0 {
+		if me.clamp(me.min) {
+			return nil
+		}
+		return userError{fmt.Sprintf("value %s is less than minimum %s", me.renderValue(), renderValue(me.min))}
+	}
+	if me.max.IsValid() && compareNumeric(me.value, me.max) > 0 {
+		if me.clamp(me.max) {
+			return nil
+		}
+		return userError{fmt.Sprintf("value %s is greater than maximum %s", me.renderValue(), renderValue(me.max))}
+	}
+	return nil
+}
+
+// Sets the value to the bound if the arg has the clamp tag, and returns whether it did.
+func (me arg) clamp(bound reflect.Value) bool {
+	if me.tag.Get("clamp") != "true" {
+		return false
+	}
+	if me.warnf != nil {
+		me.warnf("value %s for %q is out of range, using %s", me.renderValue(), me.name, me.redact(renderValue(bound)))
+	}
+	me.value.Set(bound)
+	return true
+}
+
+// Groups numeric kinds by how their values are compared. Returns reflect.Invalid for other kinds.
+func numericKind(k reflect.Kind) reflect.Kind {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int64
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return reflect.Uint64
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return reflect.Invalid
+}
+
+// Returns -1, 0 or 1 as a is less than, equal to, or greater than b, which have the same numeric
+// type.
+func compareNumeric(a, b reflect.Value) int {
+	var less, greater bool
+	switch numericKind(a.Kind()) {
+	case reflect.Int64:
+		less, greater = a.Int() < b.Int(), a.Int() > b.Int()
+	case reflect.Uint64:
+		less, greater = a.Uint() < b.Uint(), a.Uint() > b.Uint()
+	case reflect.Float64:
+		less, greater = a.Float() < b.Float(), a.Float() > b.Float()
+	}
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// Replaces the error from failing to parse a number or bool with one that names the type, and the
+// arg if it's positional, as flag errors are already wrapped with the flag name.
+func (me arg) conversionError(s string, err error) error {
+	ne, ok := err.(*strconv.NumError)
+	if !ok {
+		return err
+	}
+	msg := fmt.Sprintf("can't convert %q to %s: %v", me.redact(s), fullTypeName(me.value.Type()), ne.Err)
+	if me.positional {
+		msg = fmt.Sprintf("argument %s: %s", me.name, msg)
+	}
+	return conversionError{userError{msg}, ne}
+}
+
+// Returns the name of the type qualified with its full package path, such as
+// github.com/anacrolix/tagflag.Bytes.
+func fullTypeName(t reflect.Type) string {
+	switch {
+	case t.Kind() == reflect.Ptr:
+		return "*" + fullTypeName(t.Elem())
+	case t.Name() != "" && t.PkgPath() != "":
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+// Removes the last element of a slice value if it's equal to an earlier one.
+func (me arg) dropDuplicateElem() {
+	v := me.value
+	if v.Kind() != reflect.Slice || v.Len() == 0 {
+		return
+	}
+	last := v.Len() - 1
+	for i := 0; i < last; i++ {
+		if reflect.DeepEqual(v.Index(i).Interface(), v.Index(last).Interface()) {
+			v.SetLen(last)
+			return
+		}
+	}
+}
+
+// Returns whether t is a bool, or a pointer to one.
+func isBoolType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Bool
+}
+
+// Returns s if it's one of the choices, or there are none. With the choicesfold tag, s matches
+// choices regardless of case, and the matching choice is returned.
+func (me arg) checkChoice(s string) (string, error) {
+	if len(me.choices) == 0 {
+		return s, nil
+	}
+	fold := me.tag.Get("choicesfold") == "true"
+	for _, c := range me.choices {
+		if s == c || fold && strings.EqualFold(s, c) {
+			return c, nil
+		}
+	}
+	return s, userError{fmt.Sprintf("invalid value %q: must be one of %s", me.redact(s), strings.Join(me.choices, ", "))}
+}
+
+// Returns the values the arg may take, from the choices tag, or those allowed by a Format. Formats
+// check their own values when marshaled.
+func (me arg) allowedValues() []string {
+	if me.choices == nil {
+		if f, ok := me.value.Interface().(Format); ok {
+			return f.Allowed()
+		}
+	}
+	return me.choices
+}
+
+// Returns the name of the flag that owns the field, which differs for derived flags.
+func (me arg) fieldName() string {
+	if me.derivedFrom != "" {
+		return me.derivedFrom
+	}
+	return me.name
+}
+
+// Returns whether values given for the flag add to its field, rather than replace it.
+func (me arg) accumulates() bool {
+	if me.step != 0 {
+		return true
+	}
+	switch me.value.Kind() {
+	case reflect.Slice:
+		return !isByteSlice(me.value.Type())
+	case reflect.Map:
+		return true
+	}
+	return false
+}
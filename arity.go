@@ -14,11 +14,14 @@ type arity struct {
 func fieldArity(v reflect.Value, sf reflect.StructField) (arity arity) {
 	arity.min = 1
 	arity.max = 1
-	if v.Kind() == reflect.Slice {
+	if v.Kind() == reflect.Slice && !isByteSlice(v.Type()) {
 		arity.max = infArity
 	}
 	if sf.Tag.Get("arity") != "" {
 		switch sf.Tag.Get("arity") {
+		case "0":
+			arity.min = 0
+			arity.max = 0
 		case "?":
 			arity.min = 0
 		case "*":
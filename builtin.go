@@ -1,6 +1,7 @@
 package tagflag
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
@@ -37,6 +38,27 @@ func addBuiltinDynamicMarshaler(marshalFunc interface{}, explicitValueRequired b
 	}
 }
 
+// Renders values of registered types for display, keyed by type.
+var valueFormatters = map[reflect.Type]func(reflect.Value) string{}
+
+// Registers marshalFunc, of type func(arg string) T or func(arg string) (T, error), to parse
+// values for fields of type T, unless T implements Marshaler. If format is
+// not nil, it renders values of type T, such as defaults in usage, in place of fmt.Stringer or the
+// default formatting. Panics if marshalFunc doesn't have one of the required types. This is
+// intended to be called from init functions.
+func RegisterMarshaler(marshalFunc interface{}, explicitValueRequired bool, format func(reflect.Value) string) {
+	t := reflect.TypeOf(marshalFunc)
+	if t == nil || t.Kind() != reflect.Func || t.NumIn() != 1 || t.In(0).Kind() != reflect.String ||
+		t.NumOut() < 1 || t.NumOut() > 2 ||
+		t.NumOut() == 2 && t.Out(1) != reflect.TypeOf((*error)(nil)).Elem() {
+		panic(fmt.Sprintf("tagflag: can't register marshaler of type %T", marshalFunc))
+	}
+	addBuiltinDynamicMarshaler(marshalFunc, explicitValueRequired)
+	if format != nil {
+		valueFormatters[t.Out(0)] = format
+	}
+}
+
 func init() {
 	// These are some simple builtin types that are nice to be handled without
 	// wrappers that implement Marshaler. Note that if they return pointer
@@ -62,13 +84,126 @@ func init() {
 	addBuiltinDynamicMarshaler(func(s string) (time.Duration, error) {
 		return time.ParseDuration(s)
 	}, false)
-	addBuiltinDynamicMarshaler(func(s string) (ip net.IP, err error) {
-		ip = net.ParseIP(s)
-		if ip == nil {
-			err = fmt.Errorf("failed to parse IP")
+	addBuiltinDynamicMarshaler(parseIP, false)
+	addBuiltinDynamicMarshaler(func(s string) (net.IPAddr, error) {
+		addr, err := parseIpAddr(s)
+		if err == nil && addr.IP == nil {
+			err = errors.New("failed to parse IP")
 		}
-		return
+		return addr, err
+	}, false)
+	addBuiltinDynamicMarshaler(func(s string) (time.Time, error) {
+		return time.Parse(time.RFC3339, s)
 	}, false)
+	// Time zones are named as in the IANA database, such as America/New_York, or UTC or Local.
+	addBuiltinDynamicMarshaler(func(s string) (*time.Location, error) {
+		if s == "" {
+			// LoadLocation would return UTC.
+			return nil, errors.New("empty time zone name")
+		}
+		return time.LoadLocation(s)
+	}, true)
+	// RawMessage doesn't validate what it's given.
+	addBuiltinDynamicMarshaler(func(s string) (json.RawMessage, error) {
+		if !json.Valid([]byte(s)) {
+			var v interface{}
+			return nil, json.Unmarshal([]byte(s), &v)
+		}
+		return json.RawMessage(s), nil
+	}, true)
+}
+
+// Used for *url.URL fields with the normalize tag.
+var normalizingURLMarshaler = dynamicMarshaler{
+	marshal: func(v reflect.Value, s string) error {
+		u, err := url.Parse(s)
+		if err != nil {
+			return err
+		}
+		normalizeURL(u)
+		v.Set(reflect.ValueOf(u))
+		return nil
+	},
+}
+
+// Lowercases the scheme and host, and removes any trailing slash from the path, other than the
+// root.
+func normalizeURL(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if len(u.Path) > 1 {
+		u.Path = strings.TrimRight(u.Path, "/")
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.RawPath = ""
+	}
+}
+
+// Returns a marshaler for time.Time fields with the format tag. The format is "unix", "unixmilli"
+// or "unixnano" for integer epoch times, or otherwise a layout for time.Parse.
+func timeFormatMarshaler(format string) marshaler {
+	return dynamicMarshaler{
+		marshal: func(v reflect.Value, s string) error {
+			t, err := parseTimeFormat(format, s)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(t))
+			return nil
+		},
+	}
+}
+
+func parseTimeFormat(format, s string) (time.Time, error) {
+	switch format {
+	case "unix", "unixmilli", "unixnano":
+	default:
+		return time.Parse(format, s)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, xerrors.Errorf("parsing %s timestamp: %w", format, err)
+	}
+	// Seconds and milliseconds are split so they can't overflow nanoseconds.
+	switch format {
+	case "unix":
+		return time.Unix(n, 0), nil
+	case "unixmilli":
+		return time.Unix(n/1e3, n%1e3*1e6), nil
+	default:
+		return time.Unix(0, n), nil
+	}
+}
+
+// Parses an IP address, which may have a zone, such as fe80::1%eth0. net.IP has nowhere to store
+// the zone, so it's discarded. Use net.IPAddr to keep it. Errors don't repeat s, which may be
+// secret.
+func parseIP(s string) (ip net.IP, err error) {
+	addr, err := parseIpAddr(s)
+	if err != nil || addr.IP == nil {
+		err = errors.New("failed to parse IP")
+	}
+	return addr.IP, err
+}
+
+// Looks up the IPs of a hostname for the resolve tag. Replaced in tests to avoid the network.
+var lookupIP = net.LookupIP
+
+// Used for net.IP fields with the resolve tag. Hostnames are looked up if the value isn't an IP.
+var resolvingIPMarshaler = dynamicMarshaler{
+	marshal: func(v reflect.Value, s string) error {
+		ip, _ := parseIP(s)
+		if ip == nil {
+			ips, err := lookupIP(s)
+			if err != nil {
+				return xerrors.Errorf("resolving %q: %w", s, err)
+			}
+			ip = ips[0]
+		}
+		v.Set(reflect.ValueOf(ip))
+		return nil
+	},
 }
 
 func parseIpAddr(host string) (ret net.IPAddr, err error) {
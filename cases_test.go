@@ -1,6 +1,8 @@
 package tagflag
 
 import (
+	"errors"
+	"os"
 	"reflect"
 	"testing"
 
@@ -26,6 +28,24 @@ func errorCase(err error, args ...string) parseCase {
 	}
 }
 
+func errorIsCase(target error, args ...string) parseCase {
+	return parseCase{
+		args: args,
+		err: func(t *testing.T, err error) {
+			assert.True(t, errors.Is(err, target), "%v", err)
+		},
+	}
+}
+
+func errorMessageCase(msg string, args ...string) parseCase {
+	return parseCase{
+		args: args,
+		err: func(t *testing.T, err error) {
+			assert.EqualError(t, err, msg)
+		},
+	}
+}
+
 func anyErrorCase(args ...string) parseCase {
 	return parseCase{
 		args: args,
@@ -35,9 +55,9 @@ func anyErrorCase(args ...string) parseCase {
 	}
 }
 
-func (me parseCase) Run(t *testing.T, newCmd func() interface{}) {
+func (me parseCase) Run(t *testing.T, newCmd func() interface{}, opts ...parseOpt) {
 	cmd := newCmd()
-	err := ParseErr(cmd, me.args)
+	err := ParseErr(cmd, me.args, opts...)
 	if me.err == nil {
 		assert.NoError(t, err)
 		assert.EqualValues(t, me.expected, reflect.ValueOf(cmd).Elem().Interface(), "%v", me)
@@ -46,8 +66,28 @@ func (me parseCase) Run(t *testing.T, newCmd func() interface{}) {
 	}
 }
 
-func RunCases(t *testing.T, cases []parseCase, newCmd func() interface{}) {
+func RunCases(t *testing.T, cases []parseCase, newCmd func() interface{}, opts ...parseOpt) {
 	for _, _case := range cases {
-		_case.Run(t, newCmd)
+		_case.Run(t, newCmd, opts...)
 	}
 }
+
+// Sets the environment variable, and returns a function that restores its previous value.
+func setenv(key, value string) (restore func()) {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// Like setenv, but unsets the environment variable.
+func unsetenv(key string) (restore func()) {
+	restore = setenv(key, "")
+	os.Unsetenv(key)
+	return
+}
@@ -0,0 +1,65 @@
+package tagflag
+
+import (
+	"io"
+	"os"
+)
+
+// How the usage message is rendered.
+type usageStyle struct {
+	UsageLabelSet
+	// Whether to use ANSI escape codes.
+	color bool
+}
+
+func (p *Parser) usageStyle(w io.Writer) usageStyle {
+	color := isColorTerminal(w)
+	if p.color != nil {
+		color = *p.color
+	}
+	return usageStyle{
+		UsageLabelSet: p.usageLabels.withDefaults(),
+		color:         color,
+	}
+}
+
+const (
+	ansiBold  = "\x1b[1m"
+	ansiCyan  = "\x1b[36m"
+	ansiReset = "\x1b[0m"
+)
+
+func (me usageStyle) colorize(code, s string) string {
+	if !me.color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// Formats a section header.
+func (me usageStyle) header(s string) string {
+	return me.colorize(ansiBold, s)
+}
+
+// Formats the name of a flag or positional argument. Every name in a column has the same escape
+// codes added, so tabwriter alignment is unaffected.
+func (me usageStyle) name(s string) string {
+	return me.colorize(ansiCyan, s)
+}
+
+// Returns whether w is a terminal, and the user hasn't disabled color with NO_COLOR. See
+// https://no-color.org.
+func isColorTerminal(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
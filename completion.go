@@ -0,0 +1,67 @@
+package tagflag
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Writes a bash completion script for the program. Flags are completed by name, and positional
+// arguments per their complete tag, which is "file" (the default), "dir", or "none". Positional
+// arguments with choices complete to those instead.
+func (p *Parser) WriteBashCompletion(w io.Writer) error {
+	if p.program == "" {
+		return errors.New("program name is required for completion")
+	}
+	fn := "_" + nonIdentChars.ReplaceAllString(p.program, "_")
+	flags := p.flagNames()
+	for i := range flags {
+		flags[i] = flagPrefix + flags[i]
+	}
+	sort.Strings(flags)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintf(w, "\tlocal cur=${COMP_WORDS[COMP_CWORD]}\n")
+	fmt.Fprintf(w, "\tif [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(flags, " "))
+	fmt.Fprintf(w, "\t\treturn\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "\tlocal i n=0\n")
+	fmt.Fprintf(w, "\tfor ((i = 1; i < COMP_CWORD; i++)); do\n")
+	fmt.Fprintf(w, "\t\t[[ ${COMP_WORDS[i]} == -* ]] || ((n++))\n")
+	fmt.Fprintf(w, "\tdone\n")
+	fmt.Fprintf(w, "\tcase $n in\n")
+	for i, a := range p.posArgs {
+		pattern := fmt.Sprint(i)
+		if a.arity.max == infArity {
+			pattern = "*"
+		}
+		fmt.Fprintf(w, "\t%s) %s ;;\n", pattern, a.bashCompletion())
+		if pattern == "*" {
+			break
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -F %s %s\n", fn, p.program)
+	return nil
+}
+
+var nonIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Returns the bash that completes the positional argument.
+func (me arg) bashCompletion() string {
+	if choices := me.allowedValues(); len(choices) != 0 {
+		return fmt.Sprintf("COMPREPLY=($(compgen -W %q -- \"$cur\"))", strings.Join(choices, " "))
+	}
+	switch me.tag.Get("complete") {
+	case "dir":
+		return `COMPREPLY=($(compgen -d -- "$cur"))`
+	case "none":
+		return "COMPREPLY=()"
+	default:
+		return `COMPREPLY=($(compgen -f -- "$cur"))`
+	}
+}
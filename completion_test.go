@@ -0,0 +1,53 @@
+package tagflag
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteBashCompletion(t *testing.T) {
+	var cmd struct {
+		Verbose bool
+		Mode    string `choices:"fast,slow"`
+		StartPos
+		Action string `choices:"get,put"`
+		Dest   string `complete:"dir"`
+		Name   string `complete:"none"`
+		Files  []string
+	}
+	p, err := NewParser(&cmd, Program("my-prog"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteBashCompletion(&buf))
+	assert.Equal(t, `_my_prog() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "-mode -verbose" -- "$cur"))
+		return
+	fi
+	local i n=0
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		[[ ${COMP_WORDS[i]} == -* ]] || ((n++))
+	done
+	case $n in
+	0) COMPREPLY=($(compgen -W "get put" -- "$cur")) ;;
+	1) COMPREPLY=($(compgen -d -- "$cur")) ;;
+	2) COMPREPLY=() ;;
+	*) COMPREPLY=($(compgen -f -- "$cur")) ;;
+	esac
+}
+complete -F _my_prog my-prog
+`, buf.String())
+	var bad struct {
+		StartPos
+		File string `complete:"socket"`
+	}
+	_, err = NewParser(&bad)
+	assert.EqualError(t, err, `positional argument "File" has unknown complete tag: "socket"`)
+	p, err = NewParser(&cmd)
+	require.NoError(t, err)
+	assert.Error(t, p.WriteBashCompletion(&buf))
+}
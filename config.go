@@ -0,0 +1,173 @@
+package tagflag
+
+import (
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Writes the effective values of the flags, so that an invocation can be saved and repeated. The
+// format is "json", for an object mapping flag names to values, or "flags", for one -name=value
+// argument per line. Values are written in a form that parses back to the same value, with JSON
+// bools and numbers where the flag's type is a plain bool or number. Slice and map flags give one
+// value per element, and map entries with slice values give one per element of the slice, with
+// the key separated as by the mapsep tag. Flags that take no value, and unset pointers, are
+// omitted. The values of secret flags are redacted.
+func (p *Parser) WriteConfig(w io.Writer, format string) error {
+	values := p.configValues()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	switch format {
+	case "json":
+		obj := make(map[string]interface{}, len(values))
+		for name, vs := range values {
+			if p.flags[name].isMultiValued() {
+				obj[name] = vs
+			} else {
+				obj[name] = vs[0]
+			}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(obj)
+	case "flags":
+		for _, name := range names {
+			for _, v := range values[name] {
+				if _, err := fmt.Fprintf(w, "%s%s=%v\n", flagPrefix, name, v); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown config format: %q", format)
+	}
+}
+
+// Returns the values of the flags to be written by WriteConfig, keyed by flag name.
+func (p *Parser) configValues() map[string][]interface{} {
+	ret := make(map[string][]interface{})
+	for _, f := range p.fieldFlags() {
+		if f.arity.max == 0 {
+			continue
+		}
+		v := f.value
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		secret := f.isSecret()
+		hexBytes := f.tag.Get("encoding") == "hex" && isByteSlice(v.Type())
+		value := func(v reflect.Value) interface{} {
+			if secret {
+				return redacted
+			}
+			if hexBytes {
+				return hex.EncodeToString(v.Bytes())
+			}
+			return configValue(v)
+		}
+		if !f.isMultiValued() {
+			ret[f.name] = []interface{}{value(v)}
+			continue
+		}
+		vs := []interface{}{}
+		if v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				vs = append(vs, value(v.Index(i)))
+			}
+		} else {
+			sep := f.tag.Get("mapsep")
+			if sep == "" {
+				sep = "="
+			}
+			// Entries are sorted by key, keeping the order of the elements of slice values.
+			type entry struct{ key, s string }
+			var entries []entry
+			for _, k := range v.MapKeys() {
+				key := fmt.Sprint(value(k))
+				elem := v.MapIndex(k)
+				switch {
+				case elem.Type() == reflect.TypeOf(struct{}{}):
+					entries = append(entries, entry{key, key})
+				case elem.Kind() == reflect.Slice && !isByteSlice(elem.Type()):
+					for i := 0; i < elem.Len(); i++ {
+						entries = append(entries, entry{key, key + sep + fmt.Sprint(value(elem.Index(i)))})
+					}
+				default:
+					entries = append(entries, entry{key, key + sep + fmt.Sprint(value(elem))})
+				}
+			}
+			sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+			for _, e := range entries {
+				vs = append(vs, e.s)
+			}
+		}
+		ret[f.name] = vs
+	}
+	return ret
+}
+
+// Returns the value as WriteConfig writes it, in a form that parses back to the same value. Plain
+// bools and numbers are returned as such, and other types as text.
+func configValue(v reflect.Value) interface{} {
+	switch x := v.Interface().(type) {
+	case Bytes:
+		// Bytes.String rounds to a few significant figures.
+		return int64(x)
+	case Rate:
+		if x == Rate(math.Trunc(float64(x))) {
+			return fmt.Sprintf("%dB/s", uint64(x))
+		}
+		// Rates given in bits are whole numbers of bits.
+		return fmt.Sprintf("%db/s", uint64(x*8))
+	case Percent:
+		// Without a % suffix, the value is taken as a ratio.
+		return float64(x)
+	}
+	if _, ok := valueFormatters[v.Type()]; ok {
+		return renderValue(v)
+	}
+	i := v.Interface()
+	if v.CanAddr() {
+		i = v.Addr().Interface()
+	}
+	switch i.(type) {
+	case fmt.Stringer, encoding.TextMarshaler:
+		return renderValue(v)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return renderValue(v)
+}
+
+// Returns whether the flag is assigned one element per occurrence, so that WriteConfig gives one
+// value per element.
+func (me arg) isMultiValued() bool {
+	t := me.value.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if _, ok := valueMarshaler(t).(defaultMarshaler); !ok {
+		return false
+	}
+	return t.Kind() == reflect.Slice || t.Kind() == reflect.Map
+}
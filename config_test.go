@@ -0,0 +1,182 @@
+package tagflag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type configCmd struct {
+	Addr    string
+	Workers int
+	Debug   bool
+	Timeout time.Duration
+	Size    Bytes
+	Speed   Rate
+	Ratio   Percent
+	Tags    []string
+	Labels  map[string]string
+	Limit   *int
+	Verbose int `count:"v"`
+}
+
+func TestWriteConfigRoundTrip(t *testing.T) {
+	var cmd configCmd
+	args := []string{"-addr=:80", "-workers=3", "-debug", "-timeout=1m30s", "-size=1234567", "-speed=3bit/s", "-ratio=33.3%", "-tags=a", "-tags=b", "-labels=x=1", "-labels=y=2", "-limit=5", "-v", "-v"}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse(args))
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteConfig(&buf, "flags"))
+	assert.Equal(t, `-addr=:80
+-debug=true
+-labels=x=1
+-labels=y=2
+-limit=5
+-ratio=0.33299999999999996
+-size=1234567
+-speed=3b/s
+-tags=a
+-tags=b
+-timeout=1m30s
+-verbose=2
+-workers=3
+`, buf.String())
+	var reloaded configCmd
+	require.NoError(t, ParseErr(&reloaded, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")))
+	assert.EqualValues(t, cmd, reloaded)
+
+	buf.Reset()
+	require.NoError(t, p.WriteConfig(&buf, "json"))
+	var obj map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &obj))
+	assert.EqualValues(t, ":80", obj["addr"])
+	assert.EqualValues(t, []interface{}{"a", "b"}, obj["tags"])
+	assert.EqualValues(t, []interface{}{"x=1", "y=2"}, obj["labels"])
+	assert.EqualValues(t, 2, obj["verbose"])
+	assert.EqualValues(t, true, obj["debug"])
+	assert.EqualValues(t, 1234567, obj["size"])
+	assert.EqualValues(t, "1m30s", obj["timeout"])
+	// Numbers are kept as written, rather than converted to float64.
+	dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&obj))
+	var fromJSON []string
+	for name, v := range obj {
+		if vs, ok := v.([]interface{}); ok {
+			for _, v := range vs {
+				fromJSON = append(fromJSON, fmt.Sprintf("-%s=%v", name, v))
+			}
+		} else {
+			fromJSON = append(fromJSON, fmt.Sprintf("-%s=%v", name, v))
+		}
+	}
+	reloaded = configCmd{}
+	require.NoError(t, ParseErr(&reloaded, fromJSON))
+	assert.EqualValues(t, cmd, reloaded)
+}
+
+func TestWriteConfigMapSep(t *testing.T) {
+	type cmd struct {
+		Header map[string][]string `mapsep:": "`
+	}
+	var c cmd
+	p, err := NewParser(&c)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-header=X: b", "-header=Accept: */*", "-header=X: a"}))
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteConfig(&buf, "flags"))
+	assert.Equal(t, `-header=Accept: */*
+-header=X: b
+-header=X: a
+`, buf.String())
+	var reloaded cmd
+	require.NoError(t, ParseErr(&reloaded, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")))
+	assert.EqualValues(t, c, reloaded)
+}
+
+func TestWriteConfigHex(t *testing.T) {
+	type cmd struct {
+		Key []byte `encoding:"hex"`
+	}
+	var c cmd
+	p, err := NewParser(&c)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-key=00ff7f"}))
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteConfig(&buf, "flags"))
+	assert.Equal(t, "-key=00ff7f\n", buf.String())
+	var reloaded cmd
+	require.NoError(t, ParseErr(&reloaded, []string{strings.TrimSuffix(buf.String(), "\n")}))
+	assert.EqualValues(t, c, reloaded)
+}
+
+func TestWriteConfigSecret(t *testing.T) {
+	var cmd struct {
+		Token string `secret:"true"`
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-token=s3cret"}))
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteConfig(&buf, "flags"))
+	assert.Equal(t, "-token=***\n", buf.String())
+	assert.Error(t, p.WriteConfig(&buf, "yaml"))
+}
+
+func TestConfigDumpFlag(t *testing.T) {
+	type cmd struct {
+		Addr    string
+		Token   string `secret:"true"`
+		Workers int    `required:"true"`
+		StartPos
+		File string
+	}
+	var c cmd
+	afterParse := false
+	p, err := NewParser(&c, ConfigDumpFlag(),
+		Source(func(name string) (string, bool) { return "3", name == "workers" }),
+		AfterParse(func(interface{}) error { afterParse = true; return nil }))
+	require.NoError(t, err)
+	err = p.Parse([]string{"-print-config", "-addr=:80", "-token=hunter2"})
+	assert.Equal(t, ErrConfigDump, err)
+	assert.False(t, afterParse)
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteConfig(&buf, "json"))
+	assert.JSONEq(t, `{"addr": ":80", "token": "`+redacted+`", "workers": 3}`, buf.String())
+
+	assert.Error(t, p.Parse([]string{"-print-config=true"}))
+	RunCases(t, []parseCase{
+		errorMessageCase(`arg[0] "-print-config": unknown flag: "print-config"`, "-print-config"),
+	}, newStruct(cmd{}))
+	var conflict struct {
+		PrintConfig bool `name:"print-config"`
+	}
+	_, err = NewParser(&conflict, ConfigDumpFlag())
+	assert.Error(t, err)
+}
+
+// ParseArgs writes the configuration and exits successfully, which is checked in a subprocess.
+func TestConfigDumpFlagExit(t *testing.T) {
+	if os.Getenv("TAGFLAG_TEST_CONFIG_DUMP") == "1" {
+		var cmd struct {
+			Level string `default:"info"`
+			Port  int
+		}
+		ParseArgs(&cmd, []string{"-print-config", "-port=8080"}, ConfigDumpFlag())
+		os.Exit(3)
+	}
+	c := exec.Command(os.Args[0], "-test.run=^TestConfigDumpFlagExit$")
+	c.Env = append(os.Environ(), "TAGFLAG_TEST_CONFIG_DUMP=1")
+	out, err := c.Output()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"level": "info", "port": 8080}`, string(out))
+}
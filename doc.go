@@ -13,21 +13,110 @@
 // Supported tags include:
 //  help: a line of text to show after the option
 //  arity: defaults to 1. the number of arguments a field requires, or ? for one
-//         optional argument, + for one or more, or * for zero or more.
+//         optional argument, + for one or more, or * for zero or more. 0 is
+//         for flags that take no value, and implement Triggerer.
+//  rest: if "true", the flag takes all remaining arguments, joined by spaces,
+//        as its value.
+//  default: a value assigned by Parser.ApplyDefaults to a flag that is not
+//           otherwise set. Positional arguments are assigned their default when
+//           the Parser is created.
+//  resolve: if "true" on a net.IP field, hostnames are resolved with
+//           net.LookupIP.
+//  type: "flag" for a flag, or "pos" or "arg" for a positional argument,
+//        regardless of the field's position relative to StartPos.
+//  secret: if "true", the value is redacted wherever it would be displayed.
+//  prefix: on a struct field, the prefix for the nested flag names in place of
+//          the field name. An empty prefix adds the flags without a prefix.
+//  choices: a comma-separated list of the only values permitted.
+//  choicesfold: "true" for values to match choices regardless of case. The
+//               value is set to the matching choice.
+//  unique: if "true" on a slice field, repeated values are dropped.
+//  format: on a time.Time field, "unix", "unixmilli" or "unixnano" for an
+//          integer epoch time, or otherwise a layout for time.Parse. The
+//          default is RFC 3339.
+//  normalize: if "true" on a *url.URL field, the scheme and host are
+//             lowercased, and trailing slashes are removed from the path.
+//  group: on a struct field, the heading for its flags in the usage message.
+//         Defaults to the field name. If empty, the flags are listed with the
+//         enclosing struct's.
+//  inverse: on a bool field, the name of an additional flag that sets it to
+//           false.
+//  count, countdown: on a signed integer field, the name of an additional flag
+//                    that takes no value, and increments or decrements the
+//                    field respectively each time it occurs.
+//  nargs: on a slice flag, "+" or "*" for the flag to take all following
+//         arguments up to the next flag, requiring one or more, or zero or
+//         more, respectively.
+//  path: "true" on a string field, or a slice of them, for relative values to
+//        be resolved to cleaned absolute paths. See BasePath.
+//  ranges: "true" on a slice of integers for values such as 1-3,5 to append
+//          each integer in the comma-separated ranges, here 1, 2, 3 and 5. A
+//          value can expand to at most 65536 integers.
+//  mapsep: on a map field, the separator between keys and values in place of
+//          "=", such as ": ".
+//  list: "bracket" on a slice flag for values of the form [a,b,c] to append
+//        each element. Other values are appended as usual.
+//  encoding: "json" to parse the value as JSON, or on a byte slice, "hex" to
+//            decode the value from hex.
+//  experimental: "true" for a flag that's rejected, and hidden from usage,
+//                unless enabled by an environment variable. See ExperimentalEnv.
+//  complete: on a positional argument, "file", "dir" or "none", for how it's
+//            completed by WriteBashCompletion. The default is "file".
+//  min, max: on a numeric field, bounds on the value, given as values would be.
+//  minlen, maxlen: on a string field, bounds on the number of characters in the
+//                  value.
+//  clamp: "true" for values outside the min and max bounds to be set to the
+//         nearest bound, with a warning, instead of being an error.
+//  required: "true" for a flag that must be given, unless it's assigned by a
+//            Source.
+//  deprecated: "true" for a flag that warns when it's used. With replacedby,
+//              the name of another flag, the value is assigned to that flag
+//              instead.
+//  noenv: "true" for a flag that isn't read from the environment, even with
+//         EnvPrefix.
+//  envonly: "true" for a setting that has no flag, and is only read from the
+//           environment variable named as for EnvPrefix.
+//  env: on an envonly field, the environment variable to read, instead of the
+//       name derived from EnvPrefix. One of the two is required. It's an error
+//       on other fields.
+//  seealso: comma-separated names of related flags, referred to in the flag's
+//           usage.
+//  description: on a blank (_) field, the description of the command shown in
+//               the usage message, unless one is given with Description.
 //
 // MarshalArgs is called on fields that implement ArgsMarshaler. A number of
 // arguments matching the arity of the field are passed if possible.
 //
 // Slices will collect successive values, within the provided arity constraints.
+// Maps are populated from successive values of the form K=V, with keys and
+// values parsed as for any other field of their type. Maps with struct{} values
+// are sets, populated from successive keys. Maps with slice values, such as
+// map[string][]string, append each value to the slice for its key. Slices of
+// structs are appended an element for each value, with fields assigned from
+// comma-separated K=V pairs, where K is the field's flag name. Byte slices,
+// including named ones, are instead assigned the raw bytes of a single value.
 //
-// A few helpful types have builtin marshallers, for example Bytes,
-// *net.TCPAddr, *url.URL, time.Duration, and net.IP.
+// A few helpful types have builtin marshallers, for example Bytes, Rate,
+// Percent, Format, *net.TCPAddr, *url.URL, time.Duration, time.Time,
+// *time.Location, net.IP and net.IPAddr. IPv6 addresses may have a zone, such
+// as fe80::1%eth0, which net.IP discards. Types implementing json.Unmarshaler,
+// json.RawMessage, and maps with interface{} values are parsed as JSON. Parsing
+// for other types can be added with RegisterMarshaler.
 //
 // Flags are strictly passed with the form -K or -K=V. No space between -K and
 // the value is allowed. This allows positional arguments to be mixed in with
 // flags, and prevents any confusion due to some flags occasionally not taking
-// values. A `--` will terminate flag parsing, and treat all further arguments
-// as positional.
+// values. The long forms --K and --K=V are also accepted, and --K V takes the
+// value from the next argument, unless it's a flag, or K is a bool. A `--` will
+// terminate flag parsing, and treat all further arguments as positional. A lone
+// `-` is a positional value, assigned as is, such as for the program to take as
+// stdin. tagflag doesn't open it, or any other file.
+//
+// Fields that are nil pointers to structs are allocated only when a value within
+// the struct is set, and otherwise left nil.
+//
+// Flags of type *bool are nil unless given. -K sets them true, and -no-K sets
+// them false.
 //
 // A builtin help and usage printer are provided, and activated when passing
 // -h or -help.
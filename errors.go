@@ -1,5 +1,17 @@
 package tagflag
 
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// The command is not a pointer to a struct.
+	ErrNotStruct = errors.New("expected struct")
+	// A field in the command can't be handled as a flag, positional argument, or embedded struct.
+	ErrBadFieldType = errors.New("field has bad type")
+)
+
 type userError struct {
 	msg string
 }
@@ -7,3 +19,59 @@ type userError struct {
 func (ue userError) Error() string {
 	return ue.msg
 }
+
+// A userError for a value that couldn't be converted to the type of an arg. It unwraps to the
+// original error, such as a *strconv.NumError.
+type conversionError struct {
+	userError
+	err error
+}
+
+func (me conversionError) Unwrap() error {
+	return me.err
+}
+
+// Matches the userError, as though it had been returned directly.
+func (me conversionError) Is(target error) bool {
+	return target == error(me.userError)
+}
+
+func (me conversionError) As(target interface{}) bool {
+	if ue, ok := target.(*userError); ok {
+		*ue = me.userError
+		return true
+	}
+	return false
+}
+
+// Returned when a positional argument isn't given enough values, such as to prompt for them. Use
+// errors.As to extract it from the error returned by parsing.
+type MissingArgError struct {
+	// The name of the positional argument, as shown in usage.
+	Name string
+	// The fewest values the argument takes.
+	Min int
+	// The most values the argument takes, or -1 if there's no limit.
+	Max int
+}
+
+func (me *MissingArgError) Error() string {
+	return me.Unwrap().Error()
+}
+
+// Returns the equivalent userError, so that it's handled as any other mistake by the user.
+func (me *MissingArgError) Unwrap() error {
+	return userError{fmt.Sprintf("missing argument: %q", me.Name)}
+}
+
+func missingArgError(a arg) *MissingArgError {
+	max := a.arity.max
+	if max == infArity {
+		max = -1
+	}
+	return &MissingArgError{
+		Name: a.name,
+		Min:  a.arity.min,
+		Max:  max,
+	}
+}
@@ -0,0 +1,46 @@
+package tagflag
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An output format, such as json or table, that must be one of a fixed set of names. Create it
+// with NewFormat. The allowed names are shown in usage, and offered by completion.
+type Format struct {
+	value   string
+	allowed []string
+}
+
+var _ Marshaler = (*Format)(nil)
+
+// Returns a Format with the value defaultVal, that may be set to any of allowed.
+func NewFormat(defaultVal string, allowed ...string) Format {
+	return Format{
+		value:   defaultVal,
+		allowed: allowed,
+	}
+}
+
+func (me *Format) Marshal(s string) error {
+	for _, a := range me.allowed {
+		if s == a {
+			me.value = s
+			return nil
+		}
+	}
+	return userError{fmt.Sprintf("invalid format %q: must be one of %s", s, strings.Join(me.allowed, ", "))}
+}
+
+func (*Format) RequiresExplicitValue() bool {
+	return true
+}
+
+// Returns the names the Format may be set to.
+func (me Format) Allowed() []string {
+	return me.allowed
+}
+
+func (me Format) String() string {
+	return me.value
+}
@@ -0,0 +1,42 @@
+package tagflag
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Backs a flag added with Parser.Func.
+type funcValue struct {
+	fn func(string) error
+}
+
+var _ Marshaler = (*funcValue)(nil)
+
+func (me *funcValue) Marshal(s string) error {
+	return me.fn(s)
+}
+
+func (*funcValue) RequiresExplicitValue() bool {
+	return true
+}
+
+// Adds a flag with the given name and help that isn't backed by a field. Instead fn is called with
+// the value each time the flag is given, and any error it returns is returned from parsing. This
+// is like flag.Func.
+func (p *Parser) Func(name, help string, fn func(string) error) error {
+	if _, ok := p.lookupFlag(name); ok {
+		return fmt.Errorf("flag %q defined more than once", name)
+	}
+	if p.flags == nil {
+		p.flags = make(map[string]arg)
+	}
+	p.flags[name] = arg{
+		arity: arity{1, 1},
+		name:  name,
+		help:  help,
+		value: reflect.ValueOf(&funcValue{fn}).Elem(),
+		warnf: p.warnf,
+	}
+	p.flagOrder = append(p.flagOrder, name)
+	return nil
+}
@@ -0,0 +1,111 @@
+package tagflag
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"golang.org/x/xerrors"
+)
+
+func (p *Parser) markSet(name string) {
+	if p.set == nil {
+		p.set = make(map[string]struct{})
+	}
+	p.set[name] = struct{}{}
+	p.allocLazyPtrs(name)
+}
+
+// Returns whether the flag or positional argument with the given name has been assigned a value
+// by a source other than ApplyDefaults.
+func (p *Parser) IsSet(name string) bool {
+	_, ok := p.set[name]
+	return ok
+}
+
+// Assigns the value of the default tag to each flag that is not yet set. Defaults don't mark flags
+// as set, so any other source may override them. The defaults of positional arguments are assigned
+// when the Parser is created.
+func (p *Parser) ApplyDefaults() error {
+	for _, a := range p.fieldFlags() {
+		def, ok := a.defaultValue()
+		if !ok || p.IsSet(a.name) {
+			continue
+		}
+		p.replaceLayered(a)
+		if err := a.marshal(def, true); err != nil {
+			return xerrors.Errorf("applying default %q for %q: %w", a.redact(def), a.name, err)
+		}
+		p.markLayered(a.name)
+	}
+	return nil
+}
+
+func (p *Parser) markLayered(name string) {
+	if p.layered == nil {
+		p.layered = make(map[string]struct{})
+	}
+	p.layered[name] = struct{}{}
+}
+
+// Clears the field of flag if its value came from a lower layer and would otherwise be added to.
+func (p *Parser) replaceLayered(flag arg) {
+	name := flag.fieldName()
+	if _, ok := p.layered[name]; !ok {
+		return
+	}
+	delete(p.layered, name)
+	if flag.accumulates() {
+		flag.value.Set(reflect.Zero(flag.value.Type()))
+	}
+}
+
+// Assigns values keyed by flag name to flags that are not yet set, such as from a configuration
+// file or the environment. Sources applied earlier take precedence. Arguments parsed afterwards
+// replace the values of slice, map and count flags, rather than adding to them. Unknown flags are
+// reported before any value is assigned.
+func (p *Parser) ApplyMap(values map[string]string) error {
+	return p.applyMap(values, false)
+}
+
+// Like ApplyMap, but overrides flags that were already set.
+func (p *Parser) ForceMap(values map[string]string) error {
+	return p.applyMap(values, true)
+}
+
+func (p *Parser) applyMap(values map[string]string, force bool) error {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// Check every key before assigning any, so that an error leaves the flags as they were.
+	flags := make([]arg, 0, len(keys))
+	for _, k := range keys {
+		flag, ok := p.lookupFlag(k)
+		if !ok {
+			return userError{fmt.Sprintf("unknown flag: %q", k)}
+		}
+		if flag.arity.max == 0 {
+			return userError{fmt.Sprintf("flag %q takes no value", k)}
+		}
+		flags = append(flags, flag)
+	}
+	for i, k := range keys {
+		flag, v := flags[i], values[k]
+		if p.IsSet(flag.fieldName()) {
+			if !force {
+				continue
+			}
+			// Overriding replaces any values the field has accumulated.
+			p.markLayered(flag.fieldName())
+		}
+		p.replaceLayered(flag)
+		if err := p.marshalFlag(flag.name, flag, v, true); err != nil {
+			return xerrors.Errorf("parsing value %q for flag %q: %w", flag.redact(v), k, err)
+		}
+		p.markFlagSet(flag)
+		p.markLayered(flag.fieldName())
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package tagflag
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayers(t *testing.T) {
+	var cmd struct {
+		Addr    string `default:"localhost:80"`
+		Workers int    `default:"4"`
+		Debug   bool
+		Name    string `default:"anon"`
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-workers=8"}))
+	// Environment.
+	require.NoError(t, p.ApplyMap(map[string]string{"workers": "2", "debug": "true"}))
+	// Config file.
+	require.NoError(t, p.ApplyMap(map[string]string{"addr": ":8080", "debug": "false"}))
+	require.NoError(t, p.ApplyDefaults())
+	assert.EqualValues(t, ":8080", cmd.Addr)
+	assert.EqualValues(t, 8, cmd.Workers)
+	assert.True(t, cmd.Debug)
+	assert.EqualValues(t, "anon", cmd.Name)
+	assert.True(t, p.IsSet("workers"))
+	assert.False(t, p.IsSet("name"))
+}
+
+func TestLayersForce(t *testing.T) {
+	var cmd struct {
+		Addr string `default:"localhost:80"`
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.ApplyDefaults())
+	assert.EqualValues(t, "localhost:80", cmd.Addr)
+	require.NoError(t, p.ApplyMap(map[string]string{"addr": ":1"}))
+	require.NoError(t, p.ApplyMap(map[string]string{"addr": ":2"}))
+	assert.EqualValues(t, ":1", cmd.Addr)
+	require.NoError(t, p.ForceMap(map[string]string{"addr": ":3"}))
+	assert.EqualValues(t, ":3", cmd.Addr)
+	require.NoError(t, p.Parse([]string{"-addr=:4"}))
+	assert.EqualValues(t, ":4", cmd.Addr)
+	assert.EqualValues(t, userError{`unknown flag: "port"`}, p.ApplyMap(map[string]string{"port": "1"}))
+}
+
+func TestLayersReplaceAccumulated(t *testing.T) {
+	var cmd struct {
+		Tag       []string
+		Verbose   bool `inverse:"quiet"`
+		Verbosity int  `count:"v"`
+		Labels    map[string]string
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.ApplyMap(map[string]string{"tag": "x", "verbosity": "3", "labels": "a=1"}))
+	require.NoError(t, p.Parse([]string{"-tag=y", "-tag=z", "-v", "-labels=b=2"}))
+	assert.EqualValues(t, []string{"y", "z"}, cmd.Tag)
+	assert.EqualValues(t, 1, cmd.Verbosity)
+	assert.EqualValues(t, map[string]string{"b": "2"}, cmd.Labels)
+	require.NoError(t, p.ForceMap(map[string]string{"tag": "w"}))
+	assert.EqualValues(t, []string{"w"}, cmd.Tag)
+	// Derived flags set the field they share.
+	require.NoError(t, p.ApplyMap(map[string]string{"quiet": "true"}))
+	assert.False(t, cmd.Verbose)
+	assert.True(t, p.IsSet("verbose"))
+	require.NoError(t, p.ApplyMap(map[string]string{"verbose": "true"}))
+	assert.False(t, cmd.Verbose)
+	assert.EqualValues(t, userError{`flag "v" takes no value`}, p.ApplyMap(map[string]string{"v": "1"}))
+}
+
+func TestLayersDefaultReplaced(t *testing.T) {
+	var cmd struct {
+		Tag []string `default:"x"`
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.ApplyDefaults())
+	assert.EqualValues(t, []string{"x"}, cmd.Tag)
+	require.NoError(t, p.ApplyMap(map[string]string{"tag": "y"}))
+	assert.EqualValues(t, []string{"y"}, cmd.Tag)
+}
+
+func TestApplyMapUnknownFlagAssignsNothing(t *testing.T) {
+	var cmd struct {
+		Addr string
+		Port int
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		err := p.ApplyMap(map[string]string{"addr": ":1", "bogus": "x", "port": "2", "zzz": "y"})
+		assert.EqualValues(t, userError{`unknown flag: "bogus"`}, err)
+		assert.Empty(t, cmd.Addr)
+		assert.Zero(t, cmd.Port)
+		assert.False(t, p.IsSet("addr"))
+	}
+}
+
+func TestParseKnownFlags(t *testing.T) {
+	var cmd struct {
+		Config  string
+		Verbose bool `name:"v"`
+		StartPos
+		Files []string
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	remaining, err := p.ParseKnownFlags([]string{"a", "-config=x.conf", "-unknown=1", "-v", "-h", "b", "--", "-v"})
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"a", "-unknown=1", "-h", "b", "--", "-v"}, remaining)
+	assert.EqualValues(t, "x.conf", cmd.Config)
+	assert.True(t, cmd.Verbose)
+	assert.Empty(t, cmd.Files)
+	_, err = p.ParseKnownFlags([]string{"-v=maybe"})
+	assert.Error(t, err)
+}
+
+func TestSource(t *testing.T) {
+	var cmd struct {
+		Addr    string `default:"localhost:80"`
+		Workers int    `default:"4"`
+		Debug   bool
+		Name    string `default:"anon"`
+	}
+	store := map[string]string{"addr": ":8080", "workers": "2"}
+	p, err := NewParser(&cmd, Source(func(name string) (v string, ok bool) {
+		v, ok = store[name]
+		return
+	}))
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-workers=8"}))
+	// Environment.
+	require.NoError(t, p.ApplyMap(map[string]string{"addr": ":9090", "debug": "true"}))
+	require.NoError(t, p.ApplyDefaults())
+	assert.EqualValues(t, ":8080", cmd.Addr)
+	assert.EqualValues(t, 8, cmd.Workers)
+	assert.True(t, cmd.Debug)
+	assert.EqualValues(t, "anon", cmd.Name)
+	assert.True(t, p.IsSet("addr"))
+}
+
+func TestSourceBadValue(t *testing.T) {
+	var cmd struct {
+		Workers int
+	}
+	err := ParseErr(&cmd, nil, Source(func(string) (string, bool) { return "many", true }))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `parsing value "many" for flag "workers"`)
+}
+
+func TestEnvPrefix(t *testing.T) {
+	var cmd struct {
+		ListenAddr string
+		Workers    int
+		Reset      bool `noenv:"true"`
+		Server     struct {
+			Name string
+		}
+	}
+	for k, v := range map[string]string{
+		"MYAPP_LISTEN_ADDR": ":80",
+		"MYAPP_WORKERS":     "2",
+		"MYAPP_RESET":       "true",
+		"MYAPP_SERVER_NAME": "a",
+	} {
+		defer setenv(k, v)()
+	}
+	p, err := NewParser(&cmd, EnvPrefix("MYAPP"), Source(func(name string) (string, bool) {
+		return "3", name == "workers"
+	}))
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-listenAddr=:8080"}))
+	assert.EqualValues(t, ":8080", cmd.ListenAddr)
+	assert.EqualValues(t, 3, cmd.Workers)
+	assert.False(t, cmd.Reset)
+	assert.EqualValues(t, "a", cmd.Server.Name)
+	assert.False(t, p.IsSet("reset"))
+	defer setenv("MYAPP_WORKERS", "many")()
+	err = ParseErr(&cmd, nil, EnvPrefix("MYAPP"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "MYAPP_WORKERS")
+}
+
+func TestBoolSourcesAgree(t *testing.T) {
+	type cmd struct {
+		Verbose bool
+	}
+	// Returns the value and whether there was an error, given s with the flag.
+	fromFlag := func(s string) (bool, bool) {
+		var c cmd
+		err := ParseErr(&c, []string{"-verbose=" + s})
+		return c.Verbose, err != nil
+	}
+	for _, s := range []string{"true", "1", "t", "TRUE", "false", "0", "F", "maybe", ""} {
+		want, wantErr := fromFlag(s)
+
+		var env cmd
+		restore := setenv("APP_VERBOSE", s)
+		err := ParseErr(&env, nil, EnvPrefix("APP"))
+		restore()
+		assert.Equal(t, wantErr, err != nil, s)
+		assert.Equal(t, want, env.Verbose, s)
+
+		var fromMap cmd
+		p, err := NewParser(&fromMap)
+		require.NoError(t, err)
+		err = p.ApplyMap(map[string]string{"verbose": s})
+		assert.Equal(t, wantErr, err != nil, s)
+		assert.Equal(t, want, fromMap.Verbose, s)
+	}
+	for _, c := range []struct {
+		s   string
+		cmd interface{}
+	}{
+		{"1", &struct {
+			Verbose bool `default:"1"`
+		}{}},
+		{"TRUE", &struct {
+			Verbose bool `default:"TRUE"`
+		}{}},
+		{"F", &struct {
+			Verbose bool `default:"F"`
+		}{}},
+		{"maybe", &struct {
+			Verbose bool `default:"maybe"`
+		}{}},
+	} {
+		want, wantErr := fromFlag(c.s)
+		p, err := NewParser(c.cmd)
+		require.NoError(t, err)
+		err = p.ApplyDefaults()
+		assert.Equal(t, wantErr, err != nil, c.s)
+		assert.Equal(t, want, reflect.ValueOf(c.cmd).Elem().Field(0).Bool(), c.s)
+	}
+}
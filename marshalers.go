@@ -1,9 +1,13 @@
 package tagflag
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -15,6 +19,24 @@ type Marshaler interface {
 	RequiresExplicitValue() bool
 }
 
+// Optionally implemented by a Marshaler to parse positional arguments differently to flag values.
+// Must have a pointer receiver.
+type PositionalMarshaler interface {
+	MarshalPositional(in string) error
+}
+
+// Optionally implemented by a Marshaler to parse flag values differently to positional arguments.
+// Must have a pointer receiver.
+type FlagMarshaler interface {
+	MarshalFlag(in string) error
+}
+
+// Implemented by flag types that take no value, such as switches. Trigger is called each time the
+// flag occurs. Must have a pointer receiver.
+type Triggerer interface {
+	Trigger()
+}
+
 type marshaler interface {
 	Marshal(reflect.Value, string) error
 	RequiresExplicitValue() bool
@@ -42,6 +64,9 @@ func (defaultMarshaler) Marshal(v reflect.Value, s string) error {
 	case reflect.Slice:
 		n := reflect.New(v.Type().Elem())
 		m := valueMarshaler(n.Elem().Type())
+		if m == nil && hasExportedFields(n.Elem().Type()) {
+			m = dynamicMarshaler{marshal: marshalStructFields}
+		}
 		if m == nil {
 			return fmt.Errorf("can't marshal type %s", n.Elem().Type())
 		}
@@ -63,29 +88,29 @@ func (defaultMarshaler) Marshal(v reflect.Value, s string) error {
 		x, err := strconv.ParseInt(s, 0, 64)
 		v.SetInt(x)
 		return err
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, v.Type().Bits())
+		v.SetFloat(x)
+		return err
 	case reflect.String:
 		v.SetString(s)
 		return nil
+	case reflect.Map:
+		return marshalMapEntry(v, s)
 	case reflect.Array:
 		if v.Type().Elem().Kind() == reflect.Uint8 {
-			if len(s) == 2*v.Len() {
-				var sl []byte = v.Slice(0, v.Len()).Interface().([]byte)
-				// log.Println(cap(sl), len(sl))
-				// hex.DecodeString(s)
-				_, err := fmt.Sscanf(s, "%x", &sl)
-				// log.Println(cap(sl), sl, err)
-				if err != nil {
-					return errors.Wrapf(err, "scanning hex")
-				}
-				// Seems the slice moves as part of the decoding :|
-				reflect.Copy(v, reflect.ValueOf(sl))
-			} else {
-				return errors.Errorf("argument has unhandled length %d", len(s))
+			return marshalHexByteArray(v, s)
+		}
+		ss := strings.Split(s, ",")
+		if len(ss) != v.Len() {
+			return errors.Errorf("expected %d values for %s, got %d", v.Len(), v.Type(), len(ss))
+		}
+		for i, s := range ss {
+			if err := marshalArrayElem(v, i, s); err != nil {
+				return err
 			}
-			return nil
-		} else {
-			return errors.Errorf("unhandled array elem type: %s", v.Type().String())
 		}
+		return nil
 	default:
 		return fmt.Errorf("unhandled builtin type: %s", v.Type().String())
 	}
@@ -95,16 +120,270 @@ func (defaultMarshaler) RequiresExplicitValue() bool {
 	return true
 }
 
+// Parses s of the form K=V, and sets the entry in the map v, allocating it if necessary. Maps with
+// struct{} values are sets, and take only K.
+func marshalMapEntry(v reflect.Value, s string) error {
+	return marshalMapEntrySep(v, s, "=")
+}
+
+// Like marshalMapEntry, but K and V are separated by sep. Maps with slice values, such as
+// map[string][]string, append V to the slice for K.
+func marshalMapEntrySep(v reflect.Value, s, sep string) error {
+	t := v.Type()
+	if t.Elem() == reflect.TypeOf(struct{}{}) {
+		key := reflect.New(t.Key()).Elem()
+		if err := marshalInto(key, s); err != nil {
+			return errors.Wrapf(err, "parsing key %q", s)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		v.SetMapIndex(key, reflect.ValueOf(struct{}{}))
+		return nil
+	}
+	i := strings.Index(s, sep)
+	if i == -1 {
+		// The value is omitted, as it may be secret. Callers include it where it isn't.
+		return errors.Errorf("expected key%svalue", sep)
+	}
+	key := reflect.New(t.Key()).Elem()
+	if err := marshalInto(key, s[:i]); err != nil {
+		return errors.Wrapf(err, "parsing key %q", s[:i])
+	}
+	elem := reflect.New(t.Elem()).Elem()
+	if t.Elem().Kind() == reflect.Slice && !isByteSlice(t.Elem()) && !v.IsNil() {
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+	}
+	if err := marshalInto(elem, s[i+len(sep):]); err != nil {
+		return errors.Wrapf(err, "parsing value for key %q", s[:i])
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	v.SetMapIndex(key, elem)
+	return nil
+}
+
+// Returns whether t is a struct with exported fields.
+func hasExportedFields(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Assigns the fields of a struct from comma-separated key=value pairs, where the keys are named as
+// flags would be for the fields.
+func marshalStructFields(v reflect.Value, s string) error {
+	fields := make(map[string]reflect.Value)
+	var names []string
+	foreachStructField(v, func(fv reflect.Value, sf reflect.StructField) bool {
+		if sf.PkgPath == "" {
+			name := string(structFieldFlagNameComponent(sf))
+			fields[name] = fv
+			names = append(names, name)
+		}
+		return false
+	})
+	for n, kv := range strings.Split(s, ",") {
+		i := strings.IndexByte(kv, '=')
+		if i == -1 {
+			// The pair is identified by position, as the value may be secret.
+			return errors.Errorf("expected key=value for pair %d", n+1)
+		}
+		f, ok := fields[kv[:i]]
+		if !ok {
+			return errors.Errorf("unknown field %q, expected one of %s", kv[:i], strings.Join(names, ", "))
+		}
+		if err := marshalInto(f, kv[i+1:]); err != nil {
+			return errors.Wrapf(err, "parsing field %q", kv[:i])
+		}
+	}
+	return nil
+}
+
+// Marshals s into v using the marshaler for v's type.
+func marshalInto(v reflect.Value, s string) error {
+	m := valueMarshaler(v.Type())
+	if m == nil {
+		return fmt.Errorf("can't marshal type %s", v.Type())
+	}
+	return m.Marshal(v, s)
+}
+
+func marshalHexByteArray(v reflect.Value, s string) error {
+	need := 2 * v.Len()
+	if len(s)%2 != 0 {
+		return errors.Errorf("hex value has odd number of chars (%d), need %d for %s", len(s), need, v.Type())
+	}
+	if len(s) != need {
+		return errors.Errorf("hex value has %d chars, need %d for %s", len(s), need, v.Type())
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return errors.Wrapf(err, "decoding hex")
+	}
+	reflect.Copy(v, reflect.ValueOf(b))
+	return nil
+}
+
+// Returns whether t is a slice of bytes, including named types such as json.RawMessage. These are
+// assigned whole, rather than appended to one element at a time.
+func isByteSlice(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem() == reflect.TypeOf(byte(0))
+}
+
+// Assigns the bytes of the value to a byte slice.
+var rawBytesMarshaler = dynamicMarshaler{
+	marshal: func(v reflect.Value, s string) error {
+		v.SetBytes([]byte(s))
+		return nil
+	},
+	explicitValueRequired: true,
+}
+
+// Decodes the value from hex into a byte slice, for the encoding:"hex" tag.
+var hexBytesMarshaler = dynamicMarshaler{
+	marshal: func(v reflect.Value, s string) error {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return errors.Wrapf(err, "decoding hex")
+		}
+		v.SetBytes(b)
+		return nil
+	},
+	explicitValueRequired: true,
+}
+
+// Returns whether values of type t are arrays that are filled one element at a time, rather than
+// from hex like byte arrays.
+func isElementwiseArray(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Elem().Kind() != reflect.Uint8
+}
+
+func marshalArrayElem(v reflect.Value, i int, s string) error {
+	return marshalInto(v.Index(i), s)
+}
+
+// Parses values as JSON. Used for types that implement json.Unmarshaler, and fields with the
+// encoding:"json" tag.
+type jsonMarshaler struct{}
+
+func (jsonMarshaler) Marshal(v reflect.Value, s string) error {
+	return json.Unmarshal([]byte(s), v.Addr().Interface())
+}
+
+func (jsonMarshaler) RequiresExplicitValue() bool {
+	return true
+}
+
+// Marshals types that implement Triggerer.
+type triggerMarshaler struct{}
+
+func (triggerMarshaler) Marshal(v reflect.Value, s string) error {
+	if s != "" {
+		return errors.Errorf("takes no value")
+	}
+	v.Addr().Interface().(Triggerer).Trigger()
+	return nil
+}
+
+func (triggerMarshaler) RequiresExplicitValue() bool {
+	return false
+}
+
 type ptrMarshaler struct {
 	inner marshaler
 }
 
 func (me ptrMarshaler) Marshal(v reflect.Value, s string) error {
-	elemValue := reflect.New(v.Type().Elem())
-	v.Set(elemValue)
-	return me.inner.Marshal(elemValue.Elem(), s)
+	// Slices accumulate values, so they're allocated only once. This lets a nil *[]T mean unset.
+	if v.IsNil() || v.Type().Elem().Kind() != reflect.Slice {
+		v.Set(reflect.New(v.Type().Elem()))
+	}
+	return me.inner.Marshal(v.Elem(), s)
 }
 
 func (me ptrMarshaler) RequiresExplicitValue() bool {
 	return false
 }
+
+// Returns whether t is a slice of signed or unsigned integers.
+func isIntegerSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice {
+		return false
+	}
+	switch numericKind(t.Elem().Kind()) {
+	case reflect.Int64, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// The most integers that a single value for the ranges tag can expand to.
+const maxRangesLen = 1 << 16
+
+// Appends the integers in s, a comma-separated list of integers and inclusive ranges such as
+// 1-3,5,7-8, to the slice v. Ranges can't be reversed, or overlap, or expand to more than
+// maxRangesLen integers in total.
+func marshalRanges(v reflect.Value, s string) error {
+	seen := make(map[uint64]bool)
+	elems := v
+	var total uint64
+	for _, r := range strings.Split(s, ",") {
+		if strings.HasPrefix(r, "-") {
+			return errors.Errorf("range %q can't start with -", r)
+		}
+		lo, hi := r, r
+		if i := strings.IndexByte(r, '-'); i != -1 {
+			lo, hi = r[:i], r[i+1:]
+		}
+		first, err := strconv.ParseUint(lo, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "parsing range %q", r)
+		}
+		last, err := strconv.ParseUint(hi, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "parsing range %q", r)
+		}
+		if first > last {
+			return errors.Errorf("range %q is reversed", r)
+		}
+		// Checked before adding, as the size of a range can overflow.
+		if last-first >= maxRangesLen-total {
+			return errors.Errorf("ranges %q expand to more than %d integers", s, maxRangesLen)
+		}
+		total += last - first + 1
+		for n := first; ; n++ {
+			if seen[n] {
+				return errors.Errorf("range %q overlaps an earlier range at %d", r, n)
+			}
+			seen[n] = true
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if numericKind(elem.Kind()) == reflect.Uint64 {
+				if elem.OverflowUint(n) {
+					return errors.Errorf("%d overflows %s", n, elem.Type())
+				}
+				elem.SetUint(n)
+			} else {
+				if n > math.MaxInt64 || elem.OverflowInt(int64(n)) {
+					return errors.Errorf("%d overflows %s", n, elem.Type())
+				}
+				elem.SetInt(int64(n))
+			}
+			elems = reflect.Append(elems, elem)
+			if n == last {
+				break
+			}
+		}
+	}
+	v.Set(elems)
+	return nil
+}
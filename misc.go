@@ -1,6 +1,7 @@
 package tagflag
 
 import (
+	"encoding/json"
 	"reflect"
 	"strconv"
 	"unicode"
@@ -28,6 +29,11 @@ func canMarshal(f reflect.Value) bool {
 	return valueMarshaler(f.Type()) != nil
 }
 
+// Like canMarshal, but takes into account tags on the field that affect marshaling.
+func canMarshalField(f reflect.Value, sf reflect.StructField) bool {
+	return newArg(f, sf, sf.Name).marshaler() != nil
+}
+
 // Returns a marshaler for the given value, or nil if there isn't one.
 func valueMarshaler(t reflect.Type) marshaler {
 	if zm, ok := reflect.Zero(reflect.PtrTo(t)).Interface().(Marshaler); ok {
@@ -38,9 +44,22 @@ func valueMarshaler(t reflect.Type) marshaler {
 			explicitValueRequired: zm.RequiresExplicitValue(),
 		}
 	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*Triggerer)(nil)).Elem()) {
+		return triggerMarshaler{}
+	}
 	if bm, ok := builtinMarshalers[t]; ok {
 		return bm
 	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+		return jsonMarshaler{}
+	}
+	if isByteSlice(t) {
+		return rawBytesMarshaler
+	}
+	// There's no other way to parse arbitrary values.
+	if t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Interface {
+		return jsonMarshaler{}
+	}
 	switch t.Kind() {
 	case reflect.Ptr:
 		m := valueMarshaler(t.Elem())
@@ -51,6 +70,9 @@ func valueMarshaler(t reflect.Type) marshaler {
 	case reflect.Struct:
 		return nil
 	case reflect.Bool:
+		// Every source of values, such as arguments, defaults and the environment, parses bools
+		// here. Flags without a value are passed "true" by arg.marshal. An empty value, as in
+		// -flag=, is also true.
 		return dynamicMarshaler{
 			marshal: func(v reflect.Value, s string) error {
 				if s == "" {
@@ -83,3 +105,12 @@ func fieldFlagName(fieldName string) flagNameComponent {
 		return
 	}())
 }
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
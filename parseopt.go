@@ -1,5 +1,7 @@
 package tagflag
 
+import "io"
+
 type parseOpt func(p *Parser)
 
 // Don't perform default behaviour if -h or -help are passed.
@@ -22,14 +24,255 @@ func Program(name string) parseOpt {
 	}
 }
 
+// Sets whether flags may follow positional arguments, which is the default. When enabled, flags
+// are parsed wherever they occur, and all other arguments are assigned to positional arguments in
+// order, so a variadic positional argument receives every non-flag argument after those before it.
+// When disabled, the first positional argument ends flag parsing, as though it was preceded by
+// "--", so a variadic positional argument receives any flags that follow it verbatim.
 func ParseIntermixed(enabled bool) parseOpt {
 	return func(p *Parser) {
 		p.parseIntermixed = enabled
 	}
 }
 
+// Makes the Parser a subcommand of parent. The parent's positional arguments precede the Parser's
+// in the usage synopsis, and the Parser is included in the parent's CommandTree.
 func Parent(parent *Parser) parseOpt {
 	return func(p *Parser) {
 		p.parent = parent
 	}
 }
+
+// Values for slice flags are split on sep, with each element appended to the slice. Empty
+// elements are ignored.
+func SplitSlices(sep string) parseOpt {
+	return func(p *Parser) {
+		p.splitSlices = sep
+	}
+}
+
+// Overrides the literal text used in the usage message, such as for localization.
+func UsageLabels(labels UsageLabelSet) parseOpt {
+	return func(p *Parser) {
+		p.usageLabels = labels
+	}
+}
+
+// Unknown flags are passed through unchanged as positional arguments, or to ExcessArgs, rather than
+// being an error. This is useful for forwarding flags to a wrapped program.
+func UnknownFlagsAsPositional() parseOpt {
+	return func(p *Parser) {
+		p.unknownFlagsAsPositional = true
+	}
+}
+
+// Forces ANSI color in the usage message on or off. By default color is used only when writing to a
+// terminal, and NO_COLOR is not set.
+func Color(enabled bool) parseOpt {
+	return func(p *Parser) {
+		p.color = &enabled
+	}
+}
+
+// Each flag is given a short name of the lowercase first letter of its name, unless that name is
+// already taken, such as by an earlier flag.
+func AutoShort() parseOpt {
+	return func(p *Parser) {
+		p.autoShort = true
+	}
+}
+
+// Adds a function that's called with the command after all arguments are parsed successfully, such
+// as to validate combinations of values. Any error it returns is returned from parsing.
+func AfterParse(f func(cmd interface{}) error) parseOpt {
+	return func(p *Parser) {
+		p.afterParse = append(p.afterParse, f)
+	}
+}
+
+// Positional arguments of the form K=V, where K is the name of a flag, set the flag as though
+// -K=V was given.
+func KeyValuePositionals() parseOpt {
+	return func(p *Parser) {
+		p.keyValuePositionals = true
+	}
+}
+
+// Adds a source of values for flags not given in the arguments, such as a key-value store. The
+// getter is called with the flag name after the arguments are parsed, and its value is assigned as
+// though given with -name=value. Sources added earlier take precedence, and all take precedence
+// over anything applied after parsing, such as with ApplyMap or ApplyDefaults.
+func Source(getter func(flagName string) (string, bool)) parseOpt {
+	return func(p *Parser) {
+		p.sources = append(p.sources, getter)
+	}
+}
+
+// Adds a flag with the given name, such as "set", that takes path=value and assigns value to the
+// flag with that path. The path is the dotted name the flag has, including the names of the structs
+// that contain it, such as "server.addr". The path=value may also be given as the next argument.
+func SetPathFlag(name string) parseOpt {
+	return func(p *Parser) {
+		p.setPathFlag = name
+	}
+}
+
+// Sets the environment variable that enables flags with the experimental tag. The default is the
+// program name in upper snake case, followed by _EXPERIMENTAL, such as MYAPP_EXPERIMENTAL.
+func ExperimentalEnv(name string) parseOpt {
+	return func(p *Parser) {
+		p.experimentalEnv = name
+	}
+}
+
+// Limits the number of arguments that are parsed, as a safeguard when parsing untrusted arguments.
+// Parsing stops with an error at the first argument beyond the limit.
+func MaxArgs(n int) parseOpt {
+	return func(p *Parser) {
+		p.maxArgs = n
+	}
+}
+
+// Supplies help text for flags and positional arguments by name, taking precedence over their help
+// tags. This allows documentation to be kept in one place, or localized.
+func HelpText(help map[string]string) parseOpt {
+	return func(p *Parser) {
+		p.helpText = help
+	}
+}
+
+// Derives the names of flags, and the components of the names of flags in nested structs, from the
+// names of their fields. The default lowercases leading acronyms and the first letter, such as
+// TCPAddr to tcpAddr. Name tags take precedence.
+func NameFunc(f func(fieldName string) string) parseOpt {
+	return func(p *Parser) {
+		p.nameFunc = f
+	}
+}
+
+// Sets where warnings, such as for the use of deprecated flags, are written. The default is
+// os.Stderr.
+func WarningOutput(w io.Writer) parseOpt {
+	return func(p *Parser) {
+		p.warnings = w
+	}
+}
+
+// Flags that aren't given in the arguments, or by a Source, are read from environment variables
+// named with the prefix, an underscore, and the flag name in upper snake case, such as
+// MYAPP_LISTEN_ADDR for the flag listenAddr with prefix MYAPP. Flags with the noenv tag are
+// excluded.
+func EnvPrefix(prefix string) parseOpt {
+	return func(p *Parser) {
+		p.envPrefix = prefix
+	}
+}
+
+// Flags are named by the name in their field's json tag, if it has one, so that a struct can be
+// used for both JSON configuration and arguments. Name tags take precedence.
+func UseJSONTags() parseOpt {
+	return func(p *Parser) {
+		p.useJSONTags = true
+	}
+}
+
+// When -h or -help is passed, f is called with the usage message, and parsing stops without error
+// instead of returning ErrDefaultHelp, so that the program can continue, such as in a REPL.
+// Parser.HelpShown reports whether this happened. Has no effect with NoDefaultHelp.
+func SoftHelp(f func(usage string)) parseOpt {
+	return func(p *Parser) {
+		p.softHelp = f
+	}
+}
+
+// Sets the directory that relative values of fields with the path tag are resolved against. The
+// default is the working directory at the time of parsing.
+func BasePath(dir string) parseOpt {
+	return func(p *Parser) {
+		p.basePath = dir
+	}
+}
+
+// Adds a flag that takes no value, and is replaced by the arguments expandsTo wherever it's given,
+// such as to set several flags at once. For example, Alias("listen", []string{"-listenAddr=:80",
+// "-listenEnabled"}).
+func Alias(name string, expandsTo []string) parseOpt {
+	return func(p *Parser) {
+		if p.aliases == nil {
+			p.aliases = make(map[string][]string)
+		}
+		p.aliases[name] = expandsTo
+	}
+}
+
+// Flags that hold a single value may be given only once. Otherwise the last value given is used.
+// Flags that accumulate values, such as slices, maps and count flags, may still be repeated.
+func NoDuplicateFlags() parseOpt {
+	return func(p *Parser) {
+		p.noDuplicateFlags = true
+	}
+}
+
+// The argument tok, such as ";" as for find -exec, ends a variadic positional argument. Following
+// arguments are assigned to the positional arguments after it, and flags are parsed again, even if
+// ParseIntermixed is disabled. If tok is empty, ";" is used. A tok after "--" is positional.
+func VariadicTerminator(tok string) parseOpt {
+	return func(p *Parser) {
+		if tok == "" {
+			tok = ";"
+		}
+		p.variadicTerminator = tok
+	}
+}
+
+// Adds the flag -print-config, which stops parsing with ErrConfigDump once the arguments, Sources,
+// and environment are applied. Parse and ParseArgs then apply defaults, write the configuration as
+// JSON with WriteConfig, and exit.
+func ConfigDumpFlag() parseOpt {
+	return func(p *Parser) {
+		p.configDumpFlag = true
+	}
+}
+
+// When Parse or ParseArgs fail due to a mistake in the arguments, the error is followed by a hint to
+// use -help, rather than leaving the user to guess. The full usage is still only written when help
+// is requested.
+func ShortErrorUsage() parseOpt {
+	return func(p *Parser) {
+		p.shortErrorUsage = true
+	}
+}
+
+// Sets the width that the usage message is wrapped to. The default is from the COLUMNS environment
+// variable, or 80 if it isn't set.
+func UsageWidth(n int) parseOpt {
+	return func(p *Parser) {
+		p.width = n
+	}
+}
+
+// When there are fewer positional arguments than the optional (arity "?") positional arguments
+// could take, the optional ones are filled from the right, rather than the left. For example, with
+// USER (optional) and HOST, a single argument is assigned to HOST. Has no effect with ExcessArgs.
+func RightAlignOptionalPositionals() parseOpt {
+	return func(p *Parser) {
+		p.rightAlignOptionals = true
+	}
+}
+
+// "--" is an ordinary positional argument, rather than ending flag parsing, such as for wrappers
+// that pass it through to another program.
+func NoDoubleDashSeparator() parseOpt {
+	return func(p *Parser) {
+		p.noDoubleDashSeparator = true
+	}
+}
+
+// When there are no arguments, but positional arguments are required, parsing returns
+// ErrDefaultHelp, so that the full usage is shown as though -help was given, rather than an error
+// for the first missing argument. With SoftHelp, its function is called instead.
+func UsageOnNoArgs() parseOpt {
+	return func(p *Parser) {
+		p.usageOnNoArgs = true
+	}
+}
@@ -1,9 +1,15 @@
 package tagflag
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/anacrolix/missinggo/v2/slices"
 	"github.com/huandu/xstrings"
@@ -17,65 +23,407 @@ type Parser struct {
 	noDefaultHelp bool
 	program       string
 	description   string
+	usageLabels   UsageLabelSet
+	// The width usage is wrapped to. If zero, it's from the environment.
+	width int
+	// Forces color in usage on or off. If nil, it's used for terminals.
+	color *bool
 	// Whether the first non-option argument requires that all further arguments are to be treated
 	// as positional.
 	parseIntermixed bool
+	// Assign short names to flags automatically.
+	autoShort bool
+	// Positional arguments of the form K=V, where K is a flag, set the flag.
+	keyValuePositionals bool
+	// Unknown flags are treated as positional arguments instead of being an error.
+	unknownFlagsAsPositional bool
+	// If not empty, the values of slice flags are split on this separator into elements.
+	splitSlices string
+	// Scalar flags may be given only once.
+	noDuplicateFlags bool
+	// The fields of the flags given in the arguments, for NoDuplicateFlags.
+	givenFlags map[string]struct{}
+	// If not empty, ends a variadic positional argument, after which flags are parsed again.
+	variadicTerminator string
+	// Whether a variadic positional argument was ended by the terminator.
+	variadicEnded bool
+	// Parse errors caused by the user are followed by a hint to use -help.
+	shortErrorUsage bool
+	// Optional positional arguments are skipped from the left when there are too few values.
+	rightAlignOptionals bool
+	// "--" is an ordinary positional argument, rather than ending flag parsing.
+	noDoubleDashSeparator bool
+	// The nil struct pointers enclosing the fields being added, outermost first.
+	lazyPtrs []lazyPtr
+	// No arguments are treated as a request for help, if positional arguments are required.
+	usageOnNoArgs bool
+	// Whether -print-config is handled, and whether it was given.
+	configDumpFlag      bool
+	configDumpRequested bool
+	// Flags that are replaced by other arguments, keyed by name.
+	aliases map[string][]string
+	// Called in order with the command after successful parsing.
+	afterParse []func(cmd interface{}) error
+	// If not nil, called with the usage when help is requested, and parsing stops without error.
+	softHelp func(usage string)
+	// Whether help was requested and passed to softHelp.
+	helpShown bool
+	// If not empty, the name of a flag that takes path=value, and sets the flag with that path.
+	setPathFlag string
+	// The environment variable that enables experimental flags. If empty, it's derived from the
+	// program name.
+	experimentalEnv string
+	// Where warnings, such as for deprecated flags, are written. If nil, os.Stderr is used.
+	warnings io.Writer
+	// The directory that relative values of path fields are resolved against. If empty, the working
+	// directory is used.
+	basePath string
+	// Flag names are taken from json tags when present.
+	useJSONTags bool
+	// Derives flag names from field names, in place of fieldFlagName.
+	nameFunc func(fieldName string) string
+	// Help text keyed by flag or positional argument name, overriding help tags.
+	helpText map[string]string
+	// If not zero, the most arguments that will be parsed.
+	maxArgs int
+	// If not empty, flags not otherwise set are read from environment variables with this prefix.
+	envPrefix string
+	// Settings read only from the environment, in the order they're declared.
+	envOnly []arg
+	// Consulted in order for flags not set by the arguments.
+	sources []func(flagName string) (string, bool)
+	// Set if the command parses its own arguments.
+	argsParser ArgsParser
 	// The Parser that preceded this one, such as in sub-command relationship.
 	parent *Parser
+	// The Parsers that have this one as their parent, in the order they were created.
+	children []*Parser
 
 	posArgs []arg
 	// Maps -K=V to map[K]arg(V)
 	flags  map[string]arg
 	excess *ExcessArgs
+	// Usage groups of flags, in the order they're declared.
+	groups []string
+	// Flag names in the order they're declared.
+	flagOrder []string
+	// Maps short flag names to the flags they abbreviate.
+	shorts map[string]string
 
 	// Count of positional arguments parsed so far. Used to locate the next
 	// positional argument where it's non-trivial (non-unity arity).
 	numPos int
+	// Positional values passed over without being given, by a variadic terminator or by
+	// right-aligned optional positional arguments. Added to numPos to locate the next positional
+	// argument.
+	posSkipped int
+	// Names of the flags and positional arguments that have been assigned a value by a source
+	// other than defaults.
+	set map[string]struct{}
+	// Flags whose accumulated value came from ApplyDefaults or ApplyMap. The first source above
+	// them to set one replaces the value instead of adding to it.
+	layered map[string]struct{}
+	// The number of elements filled so far in array flags that are given one element per
+	// occurrence.
+	arrayFill map[string]int
 }
 
+// Returns whether there are flags that aren't required, which the synopsis represents collectively.
 func (p *Parser) hasOptions() bool {
-	return len(p.flags) != 0
+	for _, f := range p.flags {
+		if !f.isRequired() {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *Parser) parse(args []string) (err error) {
+	if p.argsParser != nil {
+		return p.argsParser.ParseArgs(args)
+	}
+	if len(args) == 0 && p.usageOnNoArgs && p.missingPosArg() != nil {
+		// The user likely doesn't know how to use the program, so show them.
+		if p.softHelp != nil {
+			p.showSoftHelp()
+			return nil
+		}
+		return ErrDefaultHelp
+	}
 	posOnly := false
+	var pending []pendingPos
+	// Set after --, which ends flag parsing even after a variadic terminator.
+	dashDash := false
+	numArgs := len(args)
+	if p.maxArgs != 0 && numArgs > p.maxArgs {
+		// Checked before any are parsed, as flags can consume the arguments that follow them.
+		return userError{fmt.Sprintf("too many arguments: %d given, at most %d accepted", numArgs, p.maxArgs)}
+	}
+	// The number of arguments at the front of args that came from expanding the alias at
+	// aliasIndex.
+	numExpanded, aliasIndex := 0, 0
 	for len(args) != 0 {
+		// The index of the current argument, for error messages. Arguments from an alias have the
+		// index of the alias.
+		index := numArgs - (len(args) - numExpanded)
+		if numExpanded != 0 {
+			index = aliasIndex
+		}
 		if p.excess != nil && p.nextPosArg() == nil {
 			*p.excess = args
 			return
 		}
 		a := args[0]
 		args = args[1:]
-		if !posOnly && a == "--" {
+		numExpanded = maxInt(numExpanded-1, 0)
+		// The argument as it's shown in errors, which mustn't reveal secret values.
+		shown := a
+		if !posOnly && a == "--" && !p.noDoubleDashSeparator {
 			posOnly = true
+			dashDash = true
 			continue
 		}
-		if !posOnly && isFlag(a) {
-			err = p.parseFlag(a[1:])
-			if err != nil {
-				err = xerrors.Errorf("parsing flag %q: %w", a[1:], err)
+		if exp, ok := p.lookupAlias(a); ok && !posOnly {
+			// The expansion is parsed in place of the alias, as though it was given.
+			args = append(append([]string(nil), exp...), args...)
+			numExpanded, aliasIndex = len(exp), index
+			continue
+		}
+		remaining := len(args)
+		if !dashDash && p.isVariadicTerminator(a) {
+			// Flags are parsed again after the terminator, even when not intermixed.
+			err = p.endVariadic()
+			posOnly = false
+		} else if !posOnly && isFlag(a) && a != "--" {
+			shown = p.redactFlagArg(a)
+			args, err = p.parseFlag(a[1:], args)
+		} else if !posOnly && p.isKeyValueFlag(a) {
+			shown = p.redactFlagArg(a)
+			args, err = p.parseFlag(a, args)
+		} else if p.rightAlignOptionals && p.excess == nil {
+			// Positional arguments are assigned once it's known how many there are.
+			pending = append(pending, pendingPos{index, a})
+			if !p.parseIntermixed {
+				posOnly = true
 			}
 		} else {
+			if pa := p.nextPosArg(); pa != nil {
+				shown = pa.redact(a)
+			}
 			err = p.parsePos(a)
 			if !p.parseIntermixed {
 				posOnly = true
 			}
 		}
+		// Flags can consume the arguments after them, including those from an alias.
+		numExpanded = maxInt(numExpanded-(remaining-len(args)), 0)
+		if err == ErrDefaultHelp && p.softHelp != nil {
+			p.showSoftHelp()
+			return nil
+		}
 		if err != nil {
+			if err != ErrDefaultHelp {
+				err = xerrors.Errorf("arg[%d] %q: %w", index, shown, err)
+			}
 			return
 		}
 	}
-	if p.numPos < p.minPos() {
-		return userError{fmt.Sprintf("missing argument: %q", p.indexPosArg(p.numPos).name)}
+	if err = p.parseRightAligned(pending); err != nil {
+		return
+	}
+	if err = p.applySources(); err != nil {
+		return
+	}
+	if err = p.applyEnv(); err != nil {
+		return
+	}
+	if p.configDumpRequested {
+		// The configuration is dumped whether or not it's complete.
+		return ErrConfigDump
+	}
+	if a := p.missingPosArg(); a != nil {
+		return missingArgError(*a)
+	}
+	if err = p.checkRequired(); err != nil {
+		return
+	}
+	for k, n := range p.arrayFill {
+		if l := p.flags[k].value.Len(); n != l {
+			return userError{fmt.Sprintf("flag %q needs exactly %d values, got %d", k, l, n)}
+		}
+	}
+	for _, f := range p.afterParse {
+		if err = f(p.cmd); err != nil {
+			return
+		}
 	}
 	return
 }
 
-func (p *Parser) minPos() (min int) {
-	for _, arg := range p.posArgs {
-		min += arg.arity.min
+// A positional argument, and its index in the arguments, held until all the arguments are seen.
+type pendingPos struct {
+	index int
+	s     string
+}
+
+// Assigns the positional arguments so that if there are fewer than the optional positional
+// arguments can take, those on the left are skipped, rather than those on the right.
+func (p *Parser) parseRightAligned(pending []pendingPos) error {
+	if len(pending) == 0 {
+		return nil
 	}
-	return
+	spare := len(pending)
+	var optionals []int
+	for i, a := range p.posArgs {
+		spare -= a.arity.min
+		if a.arity.min == 0 && a.arity.max == 1 {
+			optionals = append(optionals, i)
+		}
+	}
+	skip := make(map[int]bool)
+	for i := 0; i < len(optionals)-maxInt(spare, 0); i++ {
+		skip[optionals[i]] = true
+	}
+	for _, pp := range pending {
+		for {
+			i := p.posArgIndex(p.posValueIndex())
+			if i == -1 || !skip[i] {
+				break
+			}
+			delete(skip, i)
+			p.posSkipped++
+		}
+		shown := pp.s
+		if pa := p.nextPosArg(); pa != nil {
+			shown = pa.redact(pp.s)
+		}
+		if err := p.parsePos(pp.s); err != nil {
+			return xerrors.Errorf("arg[%d] %q: %w", pp.index, shown, err)
+		}
+	}
+	return nil
+}
+
+// Passes the usage to the SoftHelp function, and records that help was shown.
+func (p *Parser) showSoftHelp() {
+	var buf bytes.Buffer
+	p.WriteUsage(&buf)
+	p.helpShown = true
+	p.softHelp(buf.String())
+}
+
+// Returns whether help was requested and handled by the SoftHelp function, in which case the
+// remaining arguments weren't parsed.
+func (p *Parser) HelpShown() bool {
+	return p.helpShown
+}
+
+// Assigns flags not set by the arguments from each Source in turn.
+func (p *Parser) applySources() error {
+	for _, get := range p.sources {
+		for _, f := range p.fieldFlags() {
+			if p.IsSet(f.name) {
+				continue
+			}
+			v, ok := get(f.name)
+			if !ok {
+				continue
+			}
+			p.replaceLayered(f)
+			if err := p.marshalFlag(f.name, f, v, true); err != nil {
+				return xerrors.Errorf("parsing value %q for flag %q: %w", f.redact(v), f.name, err)
+			}
+			p.markSet(f.name)
+		}
+	}
+	return nil
+}
+
+// Returns an error for the first required flag, in declaration order, that isn't set.
+func (p *Parser) checkRequired() error {
+	for _, name := range p.flagOrder {
+		if p.flags[name].isRequired() && !p.IsSet(name) {
+			return userError{fmt.Sprintf("missing required flag: %q", name)}
+		}
+	}
+	return nil
+}
+
+// Assigns flags not otherwise set from the environment, per EnvPrefix.
+func (p *Parser) applyEnv() error {
+	for _, a := range p.envOnly {
+		env := p.envVar(a)
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := a.marshal(v, true); err != nil {
+			return xerrors.Errorf("parsing value %q from %s: %w", a.redact(v), env, err)
+		}
+		p.markSet(a.name)
+	}
+	for _, f := range p.fieldFlags() {
+		env, ok := p.flagEnvVar(f)
+		if !ok || p.IsSet(f.name) {
+			continue
+		}
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			continue
+		}
+		p.replaceLayered(f)
+		if err := p.marshalFlag(f.name, f, v, true); err != nil {
+			return xerrors.Errorf("parsing value %q for flag %q from %s: %w", f.redact(v), f.name, env, err)
+		}
+		p.markSet(f.name)
+	}
+	return nil
+}
+
+// Returns the environment variable the flag is read from, if it's read from one.
+func (p *Parser) flagEnvVar(f arg) (string, bool) {
+	if p.envPrefix == "" || f.derivedFrom != "" || f.isFunc() || f.tag.Get("noenv") == "true" {
+		return "", false
+	}
+	return p.envVar(f), true
+}
+
+// Returns the environment variable for the flag, such as MYAPP_LISTEN_ADDR for listenAddr, or for
+// an envonly setting, that given by its env tag.
+func (p *Parser) envVar(f arg) string {
+	if env := f.tag.Get("env"); env != "" {
+		return env
+	}
+	name := strings.ToUpper(strings.Replace(xstrings.ToSnakeCase(f.name), ".", "_", -1))
+	if p.envPrefix == "" {
+		return name
+	}
+	return p.envPrefix + "_" + name
+}
+
+// Returns whether a is a positional argument that sets a flag, per KeyValuePositionals.
+func (p *Parser) isKeyValueFlag(a string) bool {
+	if !p.keyValuePositionals {
+		return false
+	}
+	i := strings.IndexByte(a, '=')
+	if i == -1 {
+		return false
+	}
+	_, ok := p.lookupFlag(a[:i])
+	return ok
+}
+
+// Returns the first positional argument that wasn't given as many values as it requires, if any.
+func (p *Parser) missingPosArg() *arg {
+	start := 0
+	for i, a := range p.posArgs {
+		if maxInt(p.posValueIndex()-start, 0) < a.arity.min {
+			return &p.posArgs[i]
+		}
+		start += a.arity.max
+	}
+	return nil
 }
 
 func newParser(cmd interface{}, opts ...parseOpt) (p *Parser, err error) {
@@ -87,6 +435,30 @@ func newParser(cmd interface{}, opts ...parseOpt) (p *Parser, err error) {
 		opt(p)
 	}
 	err = p.parseCmd()
+	if _, ok := p.flags[p.setPathFlag]; err == nil && ok {
+		err = fmt.Errorf("flag %q conflicts with SetPathFlag", p.setPathFlag)
+	}
+	if _, ok := p.flags[configDumpFlagName]; err == nil && ok && p.configDumpFlag {
+		err = fmt.Errorf("flag %q conflicts with ConfigDumpFlag", configDumpFlagName)
+	}
+	if err == nil {
+		err = p.checkSeeAlso()
+	}
+	if err == nil {
+		err = p.checkAliases()
+	}
+	if err == nil {
+		err = p.applyHelpText()
+	}
+	if err == nil {
+		err = p.checkReplacedBy()
+	}
+	if err == nil && p.autoShort {
+		p.assignShortFlags()
+	}
+	if err == nil && p.parent != nil {
+		p.parent.children = append(p.parent.children, p)
+	}
 	return
 }
 
@@ -94,28 +466,52 @@ func (p *Parser) parseCmd() error {
 	if p.cmd == nil {
 		return nil
 	}
+	if ap, ok := p.cmd.(ArgsParser); ok {
+		p.argsParser = ap
+		return nil
+	}
 	s := reflect.ValueOf(p.cmd).Elem()
 	for s.Kind() == reflect.Interface {
 		s = s.Elem()
 	}
 	if s.Kind() != reflect.Struct {
-		return fmt.Errorf("expected struct got %s", s.Type())
+		return fmt.Errorf("%w: got %s", ErrNotStruct, s.Type())
 	}
-	return p.parseStruct(s, nil)
+	return p.parseStruct(s, nil, "")
 }
 
-// Positional arguments are marked per struct.
-func (p *Parser) parseStruct(st reflect.Value, path []flagNameComponent) (err error) {
+// Positional arguments are marked per struct. Flags are added to the given usage group.
+func (p *Parser) parseStruct(st reflect.Value, path []flagNameComponent, group string) (err error) {
 	posStarted := false
+	// The number of positional arguments before a Subcommand, or -1 if there isn't one.
+	subcommandPos := -1
+	defer func() {
+		if err == nil && subcommandPos != -1 && (len(p.posArgs) != subcommandPos+1 || p.excess == nil) {
+			err = fmt.Errorf("%T must be followed by one positional argument and %T", Subcommand{}, ExcessArgs{})
+		}
+	}()
 	foreachStructField(st, func(f reflect.Value, sf reflect.StructField) (stop bool) {
 		if !posStarted && f.Type() == reflect.TypeOf(StartPos{}) {
 			posStarted = true
 			return false
 		}
+		if subcommandPos == -1 && f.Type() == reflect.TypeOf(Subcommand{}) {
+			posStarted = true
+			subcommandPos = len(p.posArgs)
+			return false
+		}
 		if f.Type() == reflect.TypeOf(ExcessArgs{}) {
 			p.excess = f.Addr().Interface().(*ExcessArgs)
 			return false
 		}
+		if sf.Name == "_" {
+			// Blank fields can carry metadata for the command. The Description option takes
+			// precedence.
+			if desc := sf.Tag.Get("description"); desc != "" && p.description == "" {
+				p.description = desc
+			}
+			return false
+		}
 		if sf.PkgPath != "" {
 			return false
 		}
@@ -123,11 +519,18 @@ func (p *Parser) parseStruct(st reflect.Value, path []flagNameComponent) (err er
 			err = ErrFieldsAfterExcessArgs
 			return true
 		}
-		if canMarshal(f) {
-			if posStarted {
+		if canMarshalField(f, sf) {
+			var pos bool
+			pos, err = fieldIsPos(sf, posStarted)
+			if err != nil {
+				return true
+			}
+			if sf.Tag.Get("envonly") == "true" {
+				err = p.addEnvOnly(f, sf, path)
+			} else if pos {
 				err = p.addPos(f, sf, path)
 			} else {
-				err = p.addFlag(f, sf, path)
+				err = p.addFlag(f, sf, path, group)
 				if err != nil {
 					err = fmt.Errorf("error adding flag in %s: %s", st.Type(), err)
 				}
@@ -135,7 +538,7 @@ func (p *Parser) parseStruct(st reflect.Value, path []flagNameComponent) (err er
 			return err != nil
 		}
 		var parsed bool
-		parsed, err = p.parseEmbeddedStruct(f, sf, path)
+		parsed, err = p.parseEmbeddedStruct(f, sf, path, group)
 		if err != nil {
 			err = fmt.Errorf("parsing embedded struct: %w", err)
 			stop = true
@@ -144,14 +547,36 @@ func (p *Parser) parseStruct(st reflect.Value, path []flagNameComponent) (err er
 		if parsed {
 			return false
 		}
-		err = fmt.Errorf("field has bad type: %v", f.Type())
+		err = fmt.Errorf("%w: %v", ErrBadFieldType, f.Type())
 		return true
 	})
 	return
 }
 
-func (p *Parser) parseEmbeddedStruct(f reflect.Value, sf reflect.StructField, path []flagNameComponent) (parsed bool, err error) {
+// Returns whether the field is a positional argument. The type tag takes precedence over the
+// field's position relative to StartPos.
+func fieldIsPos(sf reflect.StructField, posStarted bool) (bool, error) {
+	switch t := sf.Tag.Get("type"); t {
+	case "":
+		return posStarted, nil
+	case "flag":
+		return false, nil
+	case "pos", "arg":
+		return true, nil
+	default:
+		return false, fmt.Errorf("field %q has unknown type tag: %q", sf.Name, t)
+	}
+}
+
+func (p *Parser) parseEmbeddedStruct(f reflect.Value, sf reflect.StructField, path []flagNameComponent, group string) (parsed bool, err error) {
 	if f.Kind() == reflect.Ptr {
+		if f.IsNil() && f.Type().Elem().Kind() == reflect.Struct {
+			// The struct is allocated only if one of its values is set.
+			lp := lazyPtr{field: f, value: reflect.New(f.Type().Elem())}
+			p.lazyPtrs = append(p.lazyPtrs, lp)
+			defer func() { p.lazyPtrs = p.lazyPtrs[:len(p.lazyPtrs)-1] }()
+			f = lp.value
+		}
 		f = f.Elem()
 	}
 	if f.Kind() != reflect.Struct {
@@ -162,24 +587,157 @@ func (p *Parser) parseEmbeddedStruct(f reflect.Value, sf reflect.StructField, pa
 		return
 	}
 	parsed = true
-	if !sf.Anonymous {
-		path = append(path, structFieldFlagNameComponent(sf))
+	if prefix, ok := sf.Tag.Lookup("prefix"); ok {
+		// An explicitly empty prefix flattens the struct's flags into the parent.
+		if prefix != "" {
+			path = append(path, flagNameComponent(prefix))
+		}
+	} else if !sf.Anonymous {
+		path = append(path, p.structFieldFlagNameComponent(sf))
 	}
-	err = p.parseStruct(f, path)
+	group = embeddedStructGroup(sf, group)
+	err = p.parseStruct(f, path, group)
 	return
 }
 
+// A nil pointer to a struct that's assigned its value when a value within the struct is set.
+type lazyPtr struct {
+	field reflect.Value
+	value reflect.Value
+}
+
+// Assigns the structs enclosing the flag or positional argument with the given name, if they were
+// nil pointers.
+func (p *Parser) allocLazyPtrs(name string) {
+	a, ok := p.flags[name]
+	if !ok {
+		for _, other := range append(p.posArgs[:len(p.posArgs):len(p.posArgs)], p.envOnly...) {
+			if other.name == name {
+				a = other
+			}
+		}
+	}
+	for _, lp := range a.lazyPtrs {
+		if lp.field.IsNil() {
+			lp.field.Set(lp.value)
+		}
+	}
+}
+
+// Returns the usage group for the flags of an embedded struct. This is the group tag, or the name
+// tag, or the field name. An empty group tag keeps the flags in the enclosing group.
+func embeddedStructGroup(sf reflect.StructField, parent string) string {
+	if g, ok := sf.Tag.Lookup("group"); ok {
+		if g == "" {
+			return parent
+		}
+		return g
+	}
+	if name := sf.Tag.Get("name"); name != "" {
+		return name
+	}
+	return sf.Name
+}
+
 func newArg(v reflect.Value, sf reflect.StructField, name string) arg {
-	return arg{
+	a := arg{
 		arity: fieldArity(v, sf),
 		value: v,
 		name:  name,
 		help:  sf.Tag.Get("help"),
+		rest:  sf.Tag.Get("rest") == "true",
+		tag:   sf.Tag,
+	}
+	if choices := sf.Tag.Get("choices"); choices != "" {
+		a.choices = strings.Split(choices, ",")
 	}
+	return a
 }
 
 func (p *Parser) addPos(f reflect.Value, sf reflect.StructField, path []flagNameComponent) error {
-	p.posArgs = append(p.posArgs, newArg(f, sf, strings.ToUpper(xstrings.ToSnakeCase(sf.Name))))
+	if fieldArity(f, sf).max == 0 {
+		return fmt.Errorf("positional argument %q can't have arity 0", sf.Name)
+	}
+	for _, tag := range []string{"rest", "nargs"} {
+		if sf.Tag.Get(tag) != "" {
+			return fmt.Errorf("positional argument %q can't have %s tag", sf.Name, tag)
+		}
+	}
+	switch c := sf.Tag.Get("complete"); c {
+	case "", "file", "dir", "none":
+	default:
+		return fmt.Errorf("positional argument %q has unknown complete tag: %q", sf.Name, c)
+	}
+	a := newArg(f, sf, strings.ToUpper(xstrings.ToSnakeCase(sf.Name)))
+	a.positional = true
+	if err := p.initArg(&a); err != nil {
+		return err
+	}
+	// Defaults of positional arguments are assigned now, as they're only overwritten by arguments.
+	if def, ok := a.defaultValue(); ok {
+		if err := a.marshal(def, true); err != nil {
+			return fmt.Errorf("applying default %q for %q: %w", def, a.name, err)
+		}
+	}
+	p.posArgs = append(p.posArgs, a)
+	return nil
+}
+
+// Returns the expansion of the argument a if it's an alias flag, such as -listen or --listen.
+func (p *Parser) lookupAlias(a string) ([]string, bool) {
+	if !isFlag(a) {
+		return nil, false
+	}
+	exp, ok := p.aliases[strings.TrimPrefix(a[1:], "-")]
+	return exp, ok
+}
+
+// Returns an error if an alias has the name of a flag, or expands to another alias.
+func (p *Parser) checkAliases() error {
+	for name, exp := range p.aliases {
+		if _, ok := p.lookupFlag(name); ok {
+			return fmt.Errorf("alias %q conflicts with flag", name)
+		}
+		for _, a := range exp {
+			if _, ok := p.lookupAlias(a); ok {
+				return fmt.Errorf("alias %q expands to alias %q", name, a)
+			}
+		}
+	}
+	return nil
+}
+
+// Completes the setup of an arg that depends on the Parser, or that can fail.
+func (p *Parser) initArg(a *arg) error {
+	a.warnf = p.warnf
+	a.lazyPtrs = append([]lazyPtr(nil), p.lazyPtrs...)
+	a.basePath = p.basePath
+	if err := a.checkPathTag(); err != nil {
+		return err
+	}
+	if err := a.parseLengthBounds(); err != nil {
+		return err
+	}
+	if err := a.checkRangesTag(); err != nil {
+		return err
+	}
+	if f, ok := a.value.Interface().(Format); ok && len(f.Allowed()) == 0 {
+		return fmt.Errorf("%q is a Format with no allowed values, create it with NewFormat", a.name)
+	}
+	return a.parseBounds()
+}
+
+// Adds a setting that's read from the environment, and has no flag.
+func (p *Parser) addEnvOnly(f reflect.Value, sf reflect.StructField, path []flagNameComponent) error {
+	a := newArg(f, sf, flagName(append(path, p.structFieldFlagNameComponent(sf))))
+	if p.envPrefix == "" && sf.Tag.Get("env") == "" {
+		// The bare name could be an unrelated variable, such as PATH or HOME.
+		return fmt.Errorf("envonly setting %q needs EnvPrefix or an env tag", a.name)
+	}
+	if err := p.initArg(&a); err != nil {
+		return err
+	}
+	p.envOnly = append(p.envOnly, a)
 	return nil
 }
 
@@ -189,23 +747,319 @@ func flagName(comps []flagNameComponent) string {
 	return strings.Join(ss, ".")
 }
 
-func (p *Parser) addFlag(f reflect.Value, sf reflect.StructField, path []flagNameComponent) error {
-	name := flagName(append(path, structFieldFlagNameComponent(sf)))
+func (p *Parser) addFlag(f reflect.Value, sf reflect.StructField, path []flagNameComponent, group string) error {
+	name := flagName(append(path, p.structFieldFlagNameComponent(sf)))
 	if _, ok := p.flags[name]; ok {
 		return fmt.Errorf("flag %q defined more than once", name)
 	}
+	if sf.Tag.Get("env") != "" {
+		return fmt.Errorf("flag %q has an env tag, which is only for envonly settings", name)
+	}
 	if p.flags == nil {
 		p.flags = make(map[string]arg)
 	}
-	p.flags[name] = newArg(f, sf, name)
+	if fieldArity(f, sf).max == 0 {
+		if _, ok := f.Addr().Interface().(Triggerer); !ok {
+			return fmt.Errorf("flag %q has arity 0, but %s does not implement Triggerer", name, f.Type())
+		}
+	}
+	switch nargs := sf.Tag.Get("nargs"); nargs {
+	case "":
+	case "+", "*":
+		if f.Kind() != reflect.Slice {
+			return fmt.Errorf("flag %q has nargs tag, but is not a slice", name)
+		}
+	default:
+		return fmt.Errorf("flag %q has unknown nargs tag: %q", name, nargs)
+	}
+	switch list := sf.Tag.Get("list"); list {
+	case "":
+	case "bracket":
+		if f.Kind() != reflect.Slice || isByteSlice(f.Type()) {
+			return fmt.Errorf("flag %q has list tag, but is not a slice", name)
+		}
+	default:
+		return fmt.Errorf("flag %q has unknown list tag: %q", name, list)
+	}
+	a := newArg(f, sf, name)
+	a.group = group
+	if err := p.initArg(&a); err != nil {
+		return err
+	}
+	p.flags[name] = a
+	p.flagOrder = append(p.flagOrder, name)
+	if inverse := sf.Tag.Get("inverse"); inverse != "" {
+		if err := p.addInverseFlag(a, inverse); err != nil {
+			return err
+		}
+	}
+	for _, c := range []struct {
+		tag  string
+		step int64
+	}{{"count", 1}, {"countdown", -1}} {
+		if countName := sf.Tag.Get(c.tag); countName != "" {
+			if err := p.addCountFlag(a, countName, c.step); err != nil {
+				return err
+			}
+		}
+	}
+	if group != "" && !containsString(p.groups, group) {
+		p.groups = append(p.groups, group)
+	}
 	return nil
 }
 
+// Adds a flag that sets the opposite value of the given bool flag.
+func (p *Parser) addInverseFlag(a arg, name string) error {
+	if a.value.Kind() != reflect.Bool {
+		return fmt.Errorf("flag %q has inverse tag, but is not a bool", a.name)
+	}
+	inv := p.derivedFlag(a, name)
+	inv.inverse = true
+	inv.help = fmt.Sprintf("sets %s%s to false", flagPrefix, a.name)
+	return p.addDerivedFlag(inv)
+}
+
+// Adds a flag that adds step to the given integer flag each time it occurs.
+func (p *Parser) addCountFlag(a arg, name string, step int64) error {
+	switch a.value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+	default:
+		return fmt.Errorf("flag %q has count tag, but is not a signed integer", a.name)
+	}
+	c := p.derivedFlag(a, name)
+	c.step = step
+	c.arity = arity{0, 0}
+	verb := "increments"
+	if step < 0 {
+		verb = "decrements"
+	}
+	c.help = fmt.Sprintf("%s %s%s", verb, flagPrefix, a.name)
+	return p.addDerivedFlag(c)
+}
+
+// Returns a flag with the given name that shares the field of a.
+func (p *Parser) derivedFlag(a arg, name string) arg {
+	d := a
+	d.name = name
+	d.derivedFrom = a.name
+	d.choices = nil
+	d.tag = ""
+	return d
+}
+
+func (p *Parser) addDerivedFlag(d arg) error {
+	if _, ok := p.flags[d.name]; ok {
+		return fmt.Errorf("flag %q defined more than once", d.name)
+	}
+	p.flags[d.name] = d
+	p.flagOrder = append(p.flagOrder, d.name)
+	return nil
+}
+
+// Records that the flag, and the flag it's derived from, if any, have been set.
+func (p *Parser) markFlagSet(flag arg) {
+	p.markSet(flag.name)
+	if flag.derivedFrom != "" {
+		p.markSet(flag.derivedFrom)
+	}
+}
+
+// With NoDuplicateFlags, returns an error if a flag that holds a single value was already given,
+// including by another flag that shares its field.
+func (p *Parser) checkDuplicateFlag(flag arg) error {
+	if !p.noDuplicateFlags || !flag.holdsSingleValue() {
+		return nil
+	}
+	field := flag.name
+	if flag.derivedFrom != "" {
+		field = flag.derivedFrom
+	}
+	if _, ok := p.givenFlags[field]; ok {
+		return userError{fmt.Sprintf("flag %q given more than once", field)}
+	}
+	if p.givenFlags == nil {
+		p.givenFlags = make(map[string]struct{})
+	}
+	p.givenFlags[field] = struct{}{}
+	return nil
+}
+
+// Returns the flag with the given name or short name.
+func (p *Parser) lookupFlag(name string) (arg, bool) {
+	if a, ok := p.flags[name]; ok {
+		return a, true
+	}
+	if long, ok := p.shorts[name]; ok {
+		return p.flags[long], true
+	}
+	return arg{}, false
+}
+
+// Gives each flag, in declaration order, a short name of the lowercased first letter of its name,
+// if that isn't already in use.
+func (p *Parser) assignShortFlags() {
+	for _, name := range p.flagOrder {
+		r, _ := utf8.DecodeRuneInString(name)
+		short := string(unicode.ToLower(r))
+		if short == name {
+			continue
+		}
+		if _, ok := p.lookupFlag(short); ok {
+			continue
+		}
+		if short == "h" && !p.noDefaultHelp {
+			continue
+		}
+		if p.shorts == nil {
+			p.shorts = make(map[string]string)
+		}
+		p.shorts[short] = name
+		a := p.flags[name]
+		a.short = short
+		p.flags[name] = a
+	}
+}
+
+// Returns the flags that correspond to distinct fields, excluding inverse flags and those added by
+// Parser.Func.
+func (p *Parser) fieldFlags() (ret []arg) {
+	for _, a := range p.flags {
+		if a.derivedFrom == "" && !a.isFunc() {
+			ret = append(ret, a)
+		}
+	}
+	return
+}
+
 func isFlag(arg string) bool {
 	return len(arg) > 1 && arg[0] == '-'
 }
 
-func (p *Parser) parseFlag(s string) error {
+// Replaces the help of flags and positional arguments with that given by HelpText.
+func (p *Parser) applyHelpText() error {
+	for name, help := range p.helpText {
+		if f, ok := p.flags[name]; ok {
+			f.help = help
+			p.flags[name] = f
+			continue
+		}
+		found := false
+		for i := range p.posArgs {
+			if p.posArgs[i].name == name {
+				p.posArgs[i].help = help
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("help text given for unknown flag or argument %q", name)
+		}
+	}
+	return nil
+}
+
+// Checks that the flags referred to by replacedby tags exist.
+func (p *Parser) checkReplacedBy() error {
+	for _, f := range p.flags {
+		if r := f.replacedBy(); r != "" {
+			if _, ok := p.flags[r]; !ok {
+				return fmt.Errorf("flag %q is replaced by unknown flag %q", f.name, r)
+			}
+		}
+	}
+	return nil
+}
+
+// Writes a warning for the user, such as about use of a deprecated flag.
+func (p *Parser) warnf(format string, a ...interface{}) {
+	w := p.warnings
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "tagflag: warning: "+format+"\n", a...)
+}
+
+// Checks that the flags referred to by seealso tags exist.
+func (p *Parser) checkSeeAlso() error {
+	for _, f := range p.flags {
+		for _, name := range f.seeAlso() {
+			if _, ok := p.lookupFlag(name); !ok {
+				return fmt.Errorf("flag %q has seealso tag referring to unknown flag %q", f.name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the environment variable that enables experimental flags.
+func (p *Parser) experimentalEnvName() string {
+	if p.experimentalEnv != "" {
+		return p.experimentalEnv
+	}
+	if p.program == "" {
+		return "EXPERIMENTAL"
+	}
+	return strings.ToUpper(xstrings.ToSnakeCase(p.program)) + "_EXPERIMENTAL"
+}
+
+func (p *Parser) experimentalEnabled() bool {
+	b, _ := strconv.ParseBool(os.Getenv(p.experimentalEnvName()))
+	return b
+}
+
+// Returns the *bool flag that k negates, as in -no-K, if there is one.
+func (p *Parser) lookupNegatedFlag(k string) (arg, bool) {
+	if !strings.HasPrefix(k, "no-") {
+		return arg{}, false
+	}
+	f, ok := p.lookupFlag(k[3:])
+	if !ok || f.value.Type() != reflect.TypeOf((*bool)(nil)) {
+		return arg{}, false
+	}
+	return f, true
+}
+
+// Returns the flag argument a with its value redacted if the flag is secret.
+func (p *Parser) redactFlagArg(a string) string {
+	i := strings.IndexByte(a, '=')
+	if i == -1 {
+		return a
+	}
+	k := strings.TrimLeft(a[:i], "-")
+	if p.setPathFlag != "" && k == p.setPathFlag {
+		// The value is itself a flag and value.
+		return a[:i+1] + p.redactFlagArg(a[i+1:])
+	}
+	if flag, ok := p.lookupFlag(k); ok && flag.isSecret() {
+		return a[:i+1] + redacted
+	}
+	return a
+}
+
+// Handles the flag enabled by SetPathFlag. The path=value is the flag's value, or the following
+// argument.
+func (p *Parser) parseSetPathFlag(explicitValue bool, v string, args []string) (rest []string, err error) {
+	rest = args
+	if !explicitValue {
+		if len(rest) == 0 {
+			return rest, userError{fmt.Sprintf("flag %q needs a value of the form path=value", p.setPathFlag)}
+		}
+		v, rest = rest[0], rest[1:]
+	}
+	i := strings.IndexByte(v, '=')
+	if i == -1 {
+		return rest, userError{fmt.Sprintf("flag %q needs a value of the form path=value, got %q", p.setPathFlag, v)}
+	}
+	if _, ok := p.lookupFlag(v[:i]); !ok {
+		return rest, p.unknownFlagError(v[:i])
+	}
+	return p.parseFlag(v, rest)
+}
+
+// Parses the flag s (without its prefix), and returns the arguments that remain after any values
+// the flag consumed from args.
+func (p *Parser) parseFlag(s string, args []string) (rest []string, err error) {
+	rest = args
 	i := strings.IndexByte(s, '=')
 	k := s
 	v := ""
@@ -213,49 +1067,304 @@ func (p *Parser) parseFlag(s string) error {
 		k = s[:i]
 		v = s[i+1:]
 	}
-	flag, ok := p.flags[k]
+	if strings.TrimLeft(k, "-") == "" {
+		return rest, userError{fmt.Sprintf("empty flag name in %q", flagPrefix+s)}
+	}
+	// A long flag, such as --addr, may take its value from the next argument.
+	long := strings.HasPrefix(k, "-")
+	if long {
+		k = k[1:]
+	}
+	if p.setPathFlag != "" && k == p.setPathFlag {
+		return p.parseSetPathFlag(i != -1, v, rest)
+	}
+	if p.configDumpFlag && k == configDumpFlagName {
+		if i != -1 {
+			return rest, userError{fmt.Sprintf("flag %q takes no value", k)}
+		}
+		p.configDumpRequested = true
+		return rest, nil
+	}
+	flag, ok := p.lookupFlag(k)
+	negated := false
 	if !ok {
+		flag, negated = p.lookupNegatedFlag(k)
+		ok = negated
+	}
+	if ok {
+		k = flag.name
+	} else {
 		if (k == "help" || k == "h") && !p.noDefaultHelp {
-			return ErrDefaultHelp
+			return rest, ErrDefaultHelp
+		}
+		if p.unknownFlagsAsPositional {
+			return rest, p.parsePosOrExcess(flagPrefix + s)
+		}
+		return rest, userError{fmt.Sprintf("unknown flag: %q", k)}
+	}
+	if flag.tag.Get("deprecated") == "true" {
+		if r := flag.replacedBy(); r != "" {
+			p.warnf("flag %q is deprecated, use %q", k, r)
+			flag = p.flags[r]
+			k = flag.name
+		} else {
+			p.warnf("flag %q is deprecated", k)
+		}
+	}
+	if flag.isExperimental() && !p.experimentalEnabled() {
+		return rest, userError{fmt.Sprintf("experimental flag, set %s=1 to enable", p.experimentalEnvName())}
+	}
+	if err = p.checkDuplicateFlag(flag); err != nil {
+		return rest, err
+	}
+	p.replaceLayered(flag)
+	explicitValue := i != -1
+	if negated {
+		if explicitValue {
+			return rest, userError{fmt.Sprintf("flag %q takes no value", "no-"+k)}
+		}
+		v, explicitValue = "false", true
+	}
+	if long && !explicitValue && flag.takesSeparateValue() && len(rest) != 0 && !isFlag(rest[0]) {
+		v, rest = rest[0], rest[1:]
+		explicitValue = true
+	}
+	if flag.rest {
+		// The flag takes all remaining arguments, space-joined, as its value.
+		vs := rest
+		if explicitValue {
+			vs = append([]string{v}, vs...)
+		}
+		v = strings.Join(vs, " ")
+		explicitValue = true
+		rest = nil
+	}
+	if flag.arity.max == 0 {
+		if explicitValue {
+			return rest, userError{fmt.Sprintf("flag %q takes no value", k)}
+		}
+		if flag.step != 0 {
+			flag.value.SetInt(flag.value.Int() + flag.step)
+		} else {
+			flag.value.Addr().Interface().(Triggerer).Trigger()
+		}
+		p.markFlagSet(flag)
+		return rest, nil
+	}
+	if nargs := flag.tag.Get("nargs"); nargs != "" {
+		var vs []string
+		if explicitValue {
+			vs = append(vs, v)
+		}
+		// Greedily take values up to the next flag.
+		for len(rest) != 0 && !isFlag(rest[0]) {
+			vs = append(vs, rest[0])
+			rest = rest[1:]
+		}
+		if len(vs) == 0 && nargs == "+" {
+			return rest, userError{fmt.Sprintf("flag %q requires at least one value", k)}
+		}
+		for _, v := range vs {
+			if err = p.marshalFlag(k, flag, v, true); err != nil {
+				return rest, xerrors.Errorf("parsing value %q for flag %q: %w", flag.redact(v), k, err)
+			}
 		}
-		return userError{fmt.Sprintf("unknown flag: %q", k)}
+		p.markFlagSet(flag)
+		return rest, nil
 	}
-	err := flag.marshal(v, i != -1)
+	err = p.marshalFlag(k, flag, v, explicitValue)
 	if err != nil {
-		return xerrors.Errorf("parsing value %q for flag %q: %w", v, k, err)
+		return rest, xerrors.Errorf("parsing value %q for flag %q: %w", flag.redact(v), k, err)
+	}
+	p.markFlagSet(flag)
+	return rest, nil
+}
+
+// Assigns a value to a flag, applying any behaviour that depends on the Parser's options or state.
+func (p *Parser) marshalFlag(name string, flag arg, v string, explicitValue bool) error {
+	t := flag.value.Type()
+	if isElementwiseArray(t) && explicitValue && !strings.Contains(v, ",") {
+		return p.marshalArrayFlagElem(name, flag, v)
+	}
+	if flag.tag.Get("list") == "bracket" && explicitValue && strings.HasPrefix(strings.TrimSpace(v), "[") {
+		return marshalBracketList(flag, v)
+	}
+	if p.splitSlices != "" && explicitValue && t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		for _, e := range strings.Split(v, p.splitSlices) {
+			// Empty elements are dropped, so an empty value appends nothing.
+			if e == "" {
+				continue
+			}
+			if err := flag.marshal(e, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return flag.marshal(v, explicitValue)
+}
+
+// Appends each element of a list of the form [a,b,c] to a slice flag. Whitespace around the
+// brackets and elements is ignored, and [] appends nothing.
+func marshalBracketList(flag arg, v string) error {
+	s := strings.TrimSpace(v)
+	if !strings.HasSuffix(s, "]") {
+		return userError{fmt.Sprintf("list %q is missing closing bracket", v)}
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	if s == "" {
+		return nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		if err := flag.marshal(strings.TrimSpace(e), true); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// Assigns the next unfilled element of an array flag.
+func (p *Parser) marshalArrayFlagElem(name string, flag arg, s string) error {
+	i := p.arrayFill[name]
+	if i >= flag.value.Len() {
+		return userError{fmt.Sprintf("too many values: flag takes exactly %d", flag.value.Len())}
+	}
+	if err := marshalArrayElem(flag.value, i, s); err != nil {
+		return err
+	}
+	if p.arrayFill == nil {
+		p.arrayFill = make(map[string]int)
+	}
+	p.arrayFill[name] = i + 1
+	return nil
+}
+
 func (p *Parser) indexPosArg(i int) *arg {
-	for _, arg := range p.posArgs {
+	if j := p.posArgIndex(i); j != -1 {
+		arg := p.posArgs[j]
+		return &arg
+	}
+	return nil
+}
+
+// Returns the index in posArgs of the positional argument that takes the ith positional value, or
+// -1 if there's none.
+func (p *Parser) posArgIndex(i int) int {
+	for j, arg := range p.posArgs {
 		if i < arg.arity.max {
-			return &arg
+			return j
 		}
 		i -= arg.arity.max
 	}
-	return nil
+	return -1
 }
 
 func (p *Parser) nextPosArg() *arg {
-	return p.indexPosArg(p.numPos)
+	return p.indexPosArg(p.posValueIndex())
+}
+
+// Returns the index of the next positional value, counting those skipped, as for posArgIndex.
+func (p *Parser) posValueIndex() int {
+	return p.numPos + p.posSkipped
+}
+
+// Returns the error for a positional argument beyond those the command accepts.
+func (p *Parser) excessArgError(s string) error {
+	if p.variadicEnded {
+		return userError{fmt.Sprintf("excess argument %q: no positional arguments remain", s)}
+	}
+	max := 0
+	for _, a := range p.posArgs {
+		if a.arity.max == infArity {
+			return userError{fmt.Sprintf("excess argument %q: too many values for variadic %s", s, a.name)}
+		}
+		max += a.arity.max
+	}
+	switch max {
+	case 0:
+		return userError{fmt.Sprintf("excess argument %q: no positional arguments accepted", s)}
+	case 1:
+		return userError{fmt.Sprintf("excess argument %q: at most 1 positional argument accepted", s)}
+	default:
+		return userError{fmt.Sprintf("excess argument %q: at most %d positional arguments accepted", s, max)}
+	}
+}
+
+// Returns whether a is the VariadicTerminator, and the next positional argument is variadic.
+func (p *Parser) isVariadicTerminator(a string) bool {
+	if p.variadicTerminator == "" || a != p.variadicTerminator {
+		return false
+	}
+	next := p.nextPosArg()
+	return next != nil && next.arity.max == infArity
+}
+
+// Ends the variadic positional argument that's being filled, so that following arguments are
+// assigned to the positional arguments after it.
+func (p *Parser) endVariadic() error {
+	start := 0
+	i := p.posValueIndex()
+	for _, a := range p.posArgs {
+		if i < start+a.arity.max {
+			if i-start < a.arity.min {
+				return missingArgError(a)
+			}
+			p.posSkipped += start + a.arity.max - i
+			p.variadicEnded = true
+			return nil
+		}
+		start += a.arity.max
+	}
+	return nil
+}
+
+// Parses s as the next positional argument, or adds it to the excess arguments if there are no
+// positional arguments remaining.
+func (p *Parser) parsePosOrExcess(s string) error {
+	if p.excess != nil && p.nextPosArg() == nil {
+		*p.excess = append(*p.excess, s)
+		return nil
+	}
+	return p.parsePos(s)
 }
 
 func (p *Parser) parsePos(s string) (err error) {
 	arg := p.nextPosArg()
 	if arg == nil {
-		return userError{fmt.Sprintf("excess argument: %q", s)}
+		return p.excessArgError(s)
+	}
+	if _, ok := arg.defaultValue(); ok && !p.IsSet(arg.name) && arg.value.Kind() == reflect.Slice {
+		// Replace the default, rather than appending to it.
+		arg.value.Set(reflect.Zero(arg.value.Type()))
 	}
 	err = arg.marshal(s, true)
 	if err != nil {
 		return
 	}
+	p.markSet(arg.name)
 	p.numPos++
 	return
 }
 
 type flagNameComponent string
 
+// Like structFieldFlagNameComponent, but uses json tags per UseJSONTags, and the NameFunc if there
+// is one.
+func (p *Parser) structFieldFlagNameComponent(sf reflect.StructField) flagNameComponent {
+	if sf.Tag.Get("name") != "" {
+		return structFieldFlagNameComponent(sf)
+	}
+	if p.useJSONTags {
+		if name := strings.SplitN(sf.Tag.Get("json"), ",", 2)[0]; name != "" && name != "-" {
+			return flagNameComponent(name)
+		}
+	}
+	if p.nameFunc == nil {
+		return structFieldFlagNameComponent(sf)
+	}
+	return flagNameComponent(p.nameFunc(sf.Name))
+}
+
 func structFieldFlagNameComponent(sf reflect.StructField) flagNameComponent {
 	name := sf.Tag.Get("name")
 	if name != "" {
@@ -263,12 +1372,3 @@ func structFieldFlagNameComponent(sf reflect.StructField) flagNameComponent {
 	}
 	return fieldFlagName(sf.Name)
 }
-
-func (p *Parser) posWithHelp() (ret []arg) {
-	for _, a := range p.posArgs {
-		if a.help != "" {
-			ret = append(ret, a)
-		}
-	}
-	return
-}
@@ -0,0 +1,46 @@
+package tagflag
+
+import (
+	"encoding"
+	"strconv"
+	"strings"
+)
+
+// A proportion, parsed from a percentage such as 75%, or a bare fraction such as 0.75. Both are
+// stored as 0.75.
+type Percent float64
+
+var (
+	_ Marshaler                = (*Percent)(nil)
+	_ encoding.TextUnmarshaler = (*Percent)(nil)
+)
+
+func (me *Percent) Marshal(s string) error {
+	div := 1.0
+	if strings.HasSuffix(s, "%") {
+		s = strings.TrimSuffix(s, "%")
+		div = 100
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*me = Percent(f / div)
+	return nil
+}
+
+func (me *Percent) UnmarshalText(text []byte) error {
+	return me.Marshal(string(text))
+}
+
+func (*Percent) RequiresExplicitValue() bool {
+	return false
+}
+
+func (me Percent) Float64() float64 {
+	return float64(me)
+}
+
+func (me Percent) String() string {
+	return strconv.FormatFloat(float64(me)*100, 'g', 10, 64) + "%"
+}
@@ -0,0 +1,68 @@
+package tagflag
+
+import (
+	"encoding"
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// A data rate, in bytes per second, such as for bandwidth limits. It's parsed from a quantity per
+// second, such as 10MB/s, or 100Mbps. An uppercase B is bytes, and a lowercase b or "bit" is bits.
+type Rate float64
+
+var (
+	_ Marshaler                = (*Rate)(nil)
+	_ encoding.TextUnmarshaler = (*Rate)(nil)
+)
+
+func (me *Rate) Marshal(s string) error {
+	q := strings.TrimSpace(s)
+	switch {
+	case strings.HasSuffix(q, "/s"):
+		q = strings.TrimSuffix(q, "/s")
+	case strings.HasSuffix(q, "ps"):
+		q = strings.TrimSuffix(q, "ps")
+	default:
+		return fmt.Errorf("rate %q has no per second suffix, such as /s or ps", s)
+	}
+	bits := false
+	switch {
+	case strings.HasSuffix(q, "bit"):
+		q, bits = strings.TrimSuffix(q, "bit")+"B", true
+	case strings.HasSuffix(q, "b"):
+		q, bits = strings.TrimSuffix(q, "b")+"B", true
+	case !strings.HasSuffix(q, "B"):
+		return fmt.Errorf("rate %q has no unit, such as B or b", s)
+	}
+	n, err := humanize.ParseBytes(q)
+	if err != nil {
+		return err
+	}
+	*me = Rate(n)
+	if bits {
+		*me /= 8
+	}
+	return nil
+}
+
+func (me *Rate) UnmarshalText(text []byte) error {
+	return me.Marshal(string(text))
+}
+
+func (*Rate) RequiresExplicitValue() bool {
+	return false
+}
+
+func (me Rate) BytesPerSec() float64 {
+	return float64(me)
+}
+
+func (me Rate) BitsPerSec() float64 {
+	return float64(me) * 8
+}
+
+func (me Rate) String() string {
+	return humanize.Bytes(uint64(me)) + "/s"
+}
@@ -0,0 +1,78 @@
+package tagflag
+
+import (
+	"errors"
+	"unicode"
+)
+
+// Splits s into arguments in the manner of a POSIX shell, without performing any expansion. Single
+// quotes preserve everything they enclose, double quotes preserve everything except backslash
+// escapes, and a backslash outside quotes escapes the next character. The result can be passed to
+// ParseErr, such as for a command line read from a file.
+func SplitArgs(s string) (ret []string, err error) {
+	var (
+		cur []rune
+		// Whether there's a current argument, which may be empty if it was quoted.
+		inArg bool
+		quote rune
+	)
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur = append(cur, r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				if i+1 == len(rs) {
+					return nil, errors.New("unterminated double quote")
+				}
+				i++
+				// Within double quotes, a backslash only escapes characters that are otherwise
+				// special.
+				if next := rs[i]; next != '"' && next != '\\' && next != '$' && next != '`' && next != '\n' {
+					cur = append(cur, r)
+				}
+				cur = append(cur, rs[i])
+			default:
+				cur = append(cur, r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == '\\':
+			if i+1 == len(rs) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			cur = append(cur, rs[i])
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				ret = append(ret, string(cur))
+				cur = cur[:0]
+				inArg = false
+			}
+		default:
+			cur = append(cur, r)
+			inArg = true
+		}
+	}
+	switch quote {
+	case '\'':
+		return nil, errors.New("unterminated single quote")
+	case '"':
+		return nil, errors.New("unterminated double quote")
+	}
+	if inArg {
+		ret = append(ret, string(cur))
+	}
+	return
+}
@@ -0,0 +1,33 @@
+package tagflag
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgs(t *testing.T) {
+	for _, _case := range []struct {
+		in       string
+		expected []string
+	}{
+		{"", nil},
+		{"  a  b\tc\n", []string{"a", "b", "c"}},
+		{`-message='hello world' x`, []string{"-message=hello world", "x"}},
+		{`"a \"b\" \$c \d" ''`, []string{`a "b" $c \d`, ""}},
+		{`'it\'s`, []string{`it\s`}},
+		{`a\ b c\\`, []string{"a b", `c\`}},
+		{`x"y"'z'`, []string{"xyz"}},
+	} {
+		actual, err := SplitArgs(_case.in)
+		require.NoError(t, err, _case.in)
+		assert.Equal(t, _case.expected, actual, _case.in)
+	}
+	_, err := SplitArgs(`'abc`)
+	assert.EqualError(t, err, "unterminated single quote")
+	_, err = SplitArgs(`"abc`)
+	assert.EqualError(t, err, "unterminated double quote")
+	_, err = SplitArgs(`abc\`)
+	assert.EqualError(t, err, "trailing backslash")
+}
@@ -0,0 +1,59 @@
+package tagflag
+
+import "fmt"
+
+// Returns the candidate closest to name by edit distance, if it's close enough to be a plausible
+// misspelling.
+func suggest(name string, candidates []string) (best string, ok bool) {
+	bestDist := len(name)/3 + 1
+	for _, c := range candidates {
+		if d := editDistance(name, c); d <= bestDist && (!ok || d < bestDist) {
+			best, bestDist, ok = c, d, true
+		}
+	}
+	return
+}
+
+// The Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	cur := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range ar {
+		cur[0] = i + 1
+		for j := range br {
+			cost := 1
+			if ar[i] == br[j] {
+				cost = 0
+			}
+			cur[j+1] = minInt(prev[j]+cost, minInt(prev[j+1]+1, cur[j]+1))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(br)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (p *Parser) flagNames() (ret []string) {
+	for name := range p.flags {
+		ret = append(ret, name)
+	}
+	return
+}
+
+// Returns an error for an unknown flag, suggesting a similar flag if there is one.
+func (p *Parser) unknownFlagError(name string) error {
+	if s, ok := suggest(name, p.flagNames()); ok {
+		return userError{fmt.Sprintf("unknown flag: %q (did you mean %q?)", name, s)}
+	}
+	return userError{fmt.Sprintf("unknown flag: %q", name)}
+}
@@ -0,0 +1,94 @@
+package tagflag
+
+import (
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+
+	"github.com/anacrolix/missinggo/v2/slices"
+)
+
+// The effective value of a flag or positional argument, as reported by Parser.Summary.
+type SummaryValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Whether the value was assigned by a source other than defaults.
+	Set bool `json:"set"`
+}
+
+// A machine-readable description of the values of a parsed command, suitable for logging.
+type Summary struct {
+	Flags      []SummaryValue `json:"flags"`
+	Positional []SummaryValue `json:"positional"`
+}
+
+// Returns the current values of all flags, ordered by name, and positional arguments.
+func (p *Parser) Summary() (ret Summary) {
+	for _, a := range p.posArgs {
+		ret.Positional = append(ret.Positional, p.summaryValue(a))
+	}
+	for _, a := range p.fieldFlags() {
+		ret.Flags = append(ret.Flags, p.summaryValue(a))
+	}
+	slices.Sort(ret.Flags, func(l, r SummaryValue) bool {
+		return l.Name < r.Name
+	})
+	return
+}
+
+func (p *Parser) summaryValue(a arg) SummaryValue {
+	return SummaryValue{
+		Name:  a.name,
+		Value: a.renderValue(),
+		Set:   p.IsSet(a.name),
+	}
+}
+
+const redacted = "***"
+
+// Renders the arg's value for display, preferring fmt.Stringer and encoding.TextMarshaler. The
+// values of secret args are redacted.
+func (me arg) renderValue() string {
+	if me.isSecret() {
+		return redacted
+	}
+	if me.tag.Get("encoding") == "hex" && isByteSlice(me.value.Type()) {
+		return hex.EncodeToString(me.value.Bytes())
+	}
+	return renderValue(me.value)
+}
+
+// Returns s, a value given for the arg, for display in diagnostics. It's redacted for secret args.
+func (me arg) redact(s string) string {
+	if me.isSecret() {
+		return redacted
+	}
+	return s
+}
+
+func renderValue(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return ""
+	}
+	if f, ok := valueFormatters[v.Type()]; ok {
+		return f(v)
+	}
+	i := v.Interface()
+	if v.CanAddr() {
+		i = v.Addr().Interface()
+	}
+	if isByteSlice(v.Type()) {
+		return string(v.Bytes())
+	}
+	switch i := i.(type) {
+	case fmt.Stringer:
+		return i.String()
+	case encoding.TextMarshaler:
+		b, err := i.MarshalText()
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,110 @@
+package tagflag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	var cmd struct {
+		Size     Bytes
+		Timeout  time.Duration `default:"1s"`
+		Password string        `secret:"true"`
+		StartPos
+		File string
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-size=1MB", "-password=hunter2", "a.txt"}))
+	require.NoError(t, p.ApplyDefaults())
+	s := p.Summary()
+	assert.EqualValues(t, Summary{
+		Flags: []SummaryValue{
+			{"password", "***", true},
+			{"size", "1.0 MB", true},
+			{"timeout", "1s", false},
+		},
+		Positional: []SummaryValue{
+			{"FILE", "a.txt", true},
+		},
+	}, s)
+	b, err := json.Marshal(s)
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "hunter2")
+}
+
+func TestSecretRedactedInUsage(t *testing.T) {
+	cmd := struct {
+		Token string `secret:"true" help:"API token"`
+		User  string
+	}{Token: "hunter2", User: "bob"}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	p.WriteUsage(&buf)
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.Contains(t, buf.String(), "API token (Default: ***)")
+	assert.Contains(t, buf.String(), "(Default: bob)")
+	require.NoError(t, p.Parse([]string{"-token=s3cret"}))
+	assert.EqualValues(t, "s3cret", cmd.Token)
+	buf.Reset()
+	p.WriteUsage(&buf)
+	assert.NotContains(t, buf.String(), "s3cret")
+	assert.NotContains(t, fmt.Sprint(p.Summary()), "s3cret")
+}
+
+func TestSecretRedactedInErrors(t *testing.T) {
+	var cmd struct {
+		Token int    `secret:"true"`
+		Pin   string `secret:"true" choices:"1234,5678"`
+		StartPos
+		Key int `secret:"true"`
+	}
+	for _, args := range [][]string{
+		{"-token=hunter2"},
+		{"--token", "hunter2"},
+		{"-pin=hunter2"},
+		{"hunter2"},
+	} {
+		err := ParseErr(&cmd, args)
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "hunter2")
+		assert.Contains(t, err.Error(), redacted)
+	}
+	assert.EqualError(t, ParseErr(&cmd, []string{"-token=hunter2"}),
+		`arg[0] "-token=***": parsing value "***" for flag "token": can't convert "***" to int: invalid syntax`)
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	err = p.ApplyMap(map[string]string{"token": "hunter2"})
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "hunter2")
+
+	var nested struct {
+		Server struct {
+			Token int `secret:"true"`
+		}
+		Addr    net.IP            `secret:"true"`
+		Headers map[string]string `secret:"true" mapsep:": "`
+	}
+	for _, args := range [][]string{
+		{"-set=server.token=hunter2"},
+		{"-set", "server.token=hunter2"},
+		{"-set=addr=hunter2"},
+		{"-addr=hunter2"},
+		{"-headers=hunter2"},
+	} {
+		err := ParseErr(&nested, args, SetPathFlag("set"))
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "hunter2", args)
+		assert.Contains(t, err.Error(), redacted, args)
+	}
+	assert.EqualError(t, ParseErr(&nested, []string{"-set=server.token=hunter2"}, SetPathFlag("set")),
+		`arg[0] "-set=server.token=***": parsing value "***" for flag "server.token": can't convert "***" to int: invalid syntax`)
+}
@@ -3,9 +3,12 @@ package tagflag
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 
 	"golang.org/x/xerrors"
 )
@@ -13,23 +16,97 @@ import (
 // Struct fields after this one are considered positional arguments.
 type StartPos struct{}
 
+// Like StartPos, but the field after this one is a subcommand name, and must be followed by
+// ExcessArgs. Arguments after the subcommand name, including flags, are left in ExcessArgs, such as
+// to be parsed for the subcommand.
+type Subcommand struct{}
+
+// Implemented by commands that parse their own arguments. The Parser passes all arguments to
+// ParseArgs instead of assigning them to the command's fields, which aren't examined. Must have a
+// pointer receiver.
+type ArgsParser interface {
+	ParseArgs(args []string) error
+}
+
 // Default help flag was provided, and should be handled.
 var ErrDefaultHelp = errors.New("help flag")
 
+// The flag enabled by ConfigDumpFlag was provided. The arguments and other sources of values were
+// applied, but requirements such as missing arguments weren't checked.
+var ErrConfigDump = errors.New("config dump flag")
+
+// The name of the flag enabled by ConfigDumpFlag.
+const configDumpFlagName = "print-config"
+
 // Parses given arguments, returning any error.
 func ParseErr(cmd interface{}, args []string, opts ...parseOpt) (err error) {
-	p, err := newParser(cmd, opts...)
+	p, err := NewParser(cmd, opts...)
 	if err != nil {
 		return
 	}
+	return p.Parse(args)
+}
+
+// Returns a Parser for cmd, which must be a pointer to a struct, or nil. Values can then be
+// assigned from several sources, such as with ApplyDefaults, ApplyMap and Parse.
+func NewParser(cmd interface{}, opts ...parseOpt) (*Parser, error) {
+	return newParser(cmd, opts...)
+}
+
+// Parses the given arguments, assigning values to the command. Values from the arguments take
+// precedence over those from any other source.
+func (p *Parser) Parse(args []string) error {
 	return p.parse(args)
 }
 
-// Parses the command-line arguments, exiting the process appropriately on
-// errors or if usage is printed.
+// Returns the number of positional arguments assigned by parsing, across all positional fields.
+// Unlike the length of a slice field, this counts each argument given, including empty ones.
+func (p *Parser) NumPositional() int {
+	return p.numPos
+}
+
+// Assigns the flags that the command defines, and returns all other arguments, including
+// positional arguments and unknown flags, in their original order. Arguments from a "--" on are
+// returned unexamined. This allows a first pass over the arguments, such as to locate a
+// configuration file, before they're fully parsed.
+func (p *Parser) ParseKnownFlags(args []string) (remaining []string, err error) {
+	for len(args) != 0 {
+		a := args[0]
+		if a == "--" && !p.noDoubleDashSeparator {
+			return append(remaining, args...), nil
+		}
+		args = args[1:]
+		if !isFlag(a) {
+			remaining = append(remaining, a)
+			continue
+		}
+		k := strings.TrimPrefix(a[1:], "-")
+		if i := strings.IndexByte(k, '='); i != -1 {
+			k = k[:i]
+		}
+		if _, ok := p.lookupFlag(k); !ok {
+			remaining = append(remaining, a)
+			continue
+		}
+		args, err = p.parseFlag(a[1:], args)
+		if err != nil {
+			return nil, xerrors.Errorf("%q: %w", a, err)
+		}
+	}
+	return
+}
+
+// Returns the command-line arguments used by Parse. It can be replaced, such as to test a program
+// that calls Parse.
+var ArgsSource = func() []string {
+	return os.Args[1:]
+}
+
+// Parses the command-line arguments, from ArgsSource, exiting the process appropriately on errors
+// or if usage is printed.
 func Parse(cmd interface{}, opts ...parseOpt) *Parser {
 	opts = append([]parseOpt{Program(filepath.Base(os.Args[0]))}, opts...)
-	return ParseArgs(cmd, os.Args[1:], opts...)
+	return ParseArgs(cmd, ArgsSource(), opts...)
 }
 
 // Like Parse, but operates on the given args instead.
@@ -39,12 +116,21 @@ func ParseArgs(cmd interface{}, args []string, opts ...parseOpt) *Parser {
 		err = p.parse(args)
 	}
 	if xerrors.Is(err, ErrDefaultHelp) {
-		p.printUsage(os.Stdout)
+		p.WriteUsage(os.Stdout)
 		os.Exit(0)
 	}
+	if xerrors.Is(err, ErrConfigDump) {
+		if err = p.ApplyDefaults(); err == nil {
+			err = p.WriteConfig(os.Stdout, "json")
+		}
+		if err == nil {
+			os.Exit(0)
+		}
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "tagflag: error parsing args: %v\n", err)
-		if _, ok := err.(userError); ok {
+		p.writeParseError(os.Stderr, err)
+		var ue userError
+		if xerrors.As(err, &ue) {
 			os.Exit(2)
 		}
 		os.Exit(1)
@@ -52,6 +138,21 @@ func ParseArgs(cmd interface{}, args []string, opts ...parseOpt) *Parser {
 	return p
 }
 
+// Writes the error from parsing arguments. With ShortErrorUsage, mistakes by the user are followed
+// by a hint to use -help.
+func (p *Parser) writeParseError(w io.Writer, err error) {
+	fmt.Fprintf(w, "tagflag: error parsing args: %v\n", err)
+	var ue userError
+	if !p.shortErrorUsage || p.noDefaultHelp || !xerrors.As(err, &ue) {
+		return
+	}
+	if p.program != "" {
+		fmt.Fprintf(w, "Try '%s %shelp' for more information.\n", p.program, flagPrefix)
+	} else {
+		fmt.Fprintf(w, "Try %shelp for more information.\n", flagPrefix)
+	}
+}
+
 func Unmarshal(arg string, v interface{}) error {
 	_v := reflect.ValueOf(v).Elem()
 	m := valueMarshaler(_v.Type())
@@ -60,3 +161,43 @@ func Unmarshal(arg string, v interface{}) error {
 	}
 	return m.Marshal(_v, arg)
 }
+
+// Returns the names of all the flags defined by cmd, sorted, without parsing any arguments. This
+// is useful for testing that a command's flags don't change unexpectedly.
+func FlagNames(cmd interface{}, opts ...parseOpt) ([]string, error) {
+	p, err := newParser(cmd, opts...)
+	if err != nil {
+		return nil, err
+	}
+	names := p.flagNames()
+	sort.Strings(names)
+	return names, nil
+}
+
+// Returns the names of the positional arguments defined by cmd, in order.
+func PositionalNames(cmd interface{}, opts ...parseOpt) (names []string, err error) {
+	p, err := newParser(cmd, opts...)
+	if err != nil {
+		return
+	}
+	for _, a := range p.posArgs {
+		names = append(names, a.name)
+	}
+	return
+}
+
+// Calls fn for each flag and positional argument of the command, in the order they're declared,
+// with its name, field value and struct tag, and whether it's positional. Flags that share the
+// field of another flag, such as from the inverse or count tags, and flags added by Func, which
+// have no field, aren't visited.
+func (p *Parser) WalkFields(fn func(name string, value reflect.Value, tag reflect.StructTag, positional bool)) {
+	for _, name := range p.flagOrder {
+		f := p.flags[name]
+		if f.derivedFrom == "" && !f.isFunc() {
+			fn(f.name, f.value, f.tag, false)
+		}
+	}
+	for _, a := range p.posArgs {
+		fn(a.name, a.value, a.tag, true)
+	}
+}
@@ -1,12 +1,21 @@
 package tagflag
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/huandu/xstrings"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/xerrors"
@@ -35,7 +44,7 @@ func TestBasic(t *testing.T) {
 		},
 		{
 			simpleCmd{},
-			userError{`excess argument: "world"`},
+			userError{`excess argument "world": at most 1 positional argument accepted`},
 			[]string{"hello", "world"},
 		},
 		{
@@ -45,7 +54,7 @@ func TestBasic(t *testing.T) {
 		},
 		{
 			simpleCmd{},
-			userError{`excess argument: "answer = 42"`},
+			userError{`excess argument "answer = 42": at most 1 positional argument accepted`},
 			[]string{"hello, world", "answer = 42"},
 		},
 		{
@@ -140,7 +149,7 @@ func TestVarious(t *testing.T) {
 	assert.NoError(t, ParseErr(a, nil))
 	assert.NoError(t, ParseErr(a, []string{"a"}))
 	assert.EqualValues(t, "a", a.A)
-	assert.EqualError(t, ParseErr(a, []string{"a", "b"}), `excess argument: "b"`)
+	assert.EqualError(t, ParseErr(a, []string{"a", "b"}), `arg[1] "b": excess argument "b": at most 1 positional argument accepted`)
 }
 
 func TestUint(t *testing.T) {
@@ -320,7 +329,7 @@ func TestSliceOfUnmarshallableStruct(t *testing.T) {
 		StartPos
 		Complex []struct{}
 	}
-	require.EqualError(t, ParseErr(&cmd, []string{"herp"}), "can't marshal type struct {}")
+	require.EqualError(t, ParseErr(&cmd, []string{"herp"}), `arg[0] "herp": can't marshal type struct {}`)
 }
 
 func newStruct(ref interface{}) func() interface{} {
@@ -336,11 +345,28 @@ func TestBasicPointer(t *testing.T) {
 	_true := true
 	RunCases(t, []parseCase{
 		noErrorCase(cmd{}),
-		errorCase(userError{`excess argument: "nope"`}, "nope"),
+		errorIsCase(userError{`excess argument "nope": no positional arguments accepted`}, "nope"),
 		noErrorCase(cmd{Maybe: &_true}, "-maybe=true"),
 	}, newStruct(cmd{}))
 }
 
+func TestPointerBoolNegation(t *testing.T) {
+	type cmd struct {
+		Maybe *bool
+		Flag  bool
+	}
+	_true, _false := true, false
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{}),
+		noErrorCase(cmd{Maybe: &_true}, "-maybe"),
+		noErrorCase(cmd{Maybe: &_false}, "--no-maybe"),
+		noErrorCase(cmd{Maybe: &_false}, "-no-maybe"),
+		noErrorCase(cmd{Maybe: &_true}, "-no-maybe", "-maybe"),
+		errorIsCase(userError{`flag "no-maybe" takes no value`}, "--no-maybe=true"),
+		errorIsCase(userError{`unknown flag: "no-flag"`}, "-no-flag"),
+	}, newStruct(cmd{}))
+}
+
 func TestMarshalByteArray(t *testing.T) {
 	type cmd struct {
 		StartPos
@@ -348,8 +374,10 @@ func TestMarshalByteArray(t *testing.T) {
 	}
 	RunCases(t, []parseCase{
 		noErrorCase(cmd{Bs: func() (ret [2]byte) { copy(ret[:], "AB"); return }()}, "4142"),
-		anyErrorCase("41424"),
-		// anyErrorCase("4142"),
+		errorMessageCase(`arg[0] "41424": hex value has odd number of chars (5), need 4 for [2]uint8`, "41424"),
+		errorMessageCase(`arg[0] "414243": hex value has 6 chars, need 4 for [2]uint8`, "414243"),
+		errorMessageCase(`arg[0] "41": hex value has 2 chars, need 4 for [2]uint8`, "41"),
+		anyErrorCase("41zz"),
 	}, newStruct(cmd{}))
 }
 
@@ -364,3 +392,1634 @@ func TestMarshalStruct(t *testing.T) {
 	}
 	ParseErr(&cmd, []string{"-struct", "structpos"})
 }
+
+func TestRestFlag(t *testing.T) {
+	type cmd struct {
+		Verbose bool   `name:"v"`
+		Message string `rest:"true"`
+		StartPos
+		Arg string `arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Message: "foo bar baz"}, "-message", "foo", "bar", "baz"),
+		noErrorCase(cmd{Verbose: true, Message: "-v -- baz"}, "-v", "-message", "-v", "--", "baz"),
+		noErrorCase(cmd{Arg: "a", Message: "foo bar"}, "a", "-message=foo", "bar"),
+		noErrorCase(cmd{}, "-message"),
+	}, newStruct(cmd{}))
+}
+
+func TestRestFlagMissingPositional(t *testing.T) {
+	var cmd struct {
+		Message string `rest:"true"`
+		StartPos
+		Arg string
+	}
+	assert.EqualValues(t, &MissingArgError{Name: "ARG", Min: 1, Max: 1}, ParseErr(&cmd, []string{"-message", "a"}))
+	assert.EqualValues(t, "a", cmd.Message)
+}
+
+func TestRestPositionalInvalid(t *testing.T) {
+	var cmd struct {
+		StartPos
+		Arg string `rest:"true"`
+	}
+	assert.Error(t, ParseErr(&cmd, nil))
+}
+
+func TestIP(t *testing.T) {
+	var cmd struct {
+		IP net.IP
+	}
+	assert.Error(t, ParseErr(&cmd, []string{"-ip=localhost"}))
+	assert.Nil(t, cmd.IP)
+	require.NoError(t, ParseErr(&cmd, []string{"-ip=127.0.0.1"}))
+	assert.EqualValues(t, "127.0.0.1", cmd.IP.String())
+}
+
+func TestResolveIP(t *testing.T) {
+	defer func(old func(string) ([]net.IP, error)) { lookupIP = old }(lookupIP)
+	lookupIP = func(host string) ([]net.IP, error) {
+		if host == "localhost" {
+			return []net.IP{net.IPv4(127, 0, 0, 1)}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var cmd struct {
+		IP net.IP `resolve:"true"`
+	}
+	require.NoError(t, ParseErr(&cmd, []string{"-ip=10.0.0.1"}))
+	assert.EqualValues(t, "10.0.0.1", cmd.IP.String())
+	require.NoError(t, ParseErr(&cmd, []string{"-ip=localhost"}))
+	assert.True(t, cmd.IP.IsLoopback(), "%v", cmd.IP)
+	var dnsErr *net.DNSError
+	assert.True(t, xerrors.As(ParseErr(&cmd, []string{"-ip=no-such-host.invalid"}), &dnsErr))
+}
+
+func TestMarshalArray(t *testing.T) {
+	type cmd struct {
+		Coords [3]int
+		StartPos
+		Pos [2]string `arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Coords: [3]int{1, 2, 3}}, "-coords=1,2,3"),
+		noErrorCase(cmd{Coords: [3]int{4, 5, 6}}, "-coords=4", "-coords=5", "-coords=6"),
+		noErrorCase(cmd{Pos: [2]string{"a", "b"}}, "a,b"),
+		anyErrorCase("-coords=1,2"),
+		anyErrorCase("-coords=1,2,3,4"),
+		anyErrorCase("-coords=1,x,3"),
+		errorCase(userError{`flag "coords" needs exactly 3 values, got 2`}, "-coords=1", "-coords=2"),
+		anyErrorCase("-coords=1", "-coords=2", "-coords=3", "-coords=4"),
+		anyErrorCase("a,b,c"),
+	}, newStruct(cmd{}))
+}
+
+func TestTypeTag(t *testing.T) {
+	type cmd struct {
+		Verbose bool   `name:"v"`
+		File    string `type:"pos"`
+		StartPos
+		Level int      `type:"flag"`
+		Rest  []string `type:"arg" arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{File: "a"}, "a"),
+		noErrorCase(cmd{Verbose: true, File: "a", Level: 2, Rest: []string{"b", "c"}}, "a", "-level=2", "b", "-v", "c"),
+		errorCase(&MissingArgError{Name: "FILE", Min: 1, Max: 1}, "-v"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		A string `type:"other"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+}
+
+func TestSplitSlices(t *testing.T) {
+	type cmd struct {
+		Tag    []string
+		Port   []int
+		Single string
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Tag: []string{"a", "b", "c"}}, "-tag=a,b", "-tag=c"),
+		noErrorCase(cmd{Tag: []string{"a", "b"}}, "-tag=a,,b,"),
+		noErrorCase(cmd{}, "-tag="),
+		noErrorCase(cmd{Port: []int{80, 443}, Single: "x,y"}, "-port=80,443", "-single=x,y"),
+		anyErrorCase("-port=80,https"),
+	}, newStruct(cmd{}), SplitSlices(","))
+}
+
+func TestSetupErrorSentinels(t *testing.T) {
+	i := 1
+	err := ParseErr(&i, nil)
+	assert.True(t, errors.Is(err, ErrNotStruct), "%v", err)
+	assert.EqualError(t, err, "expected struct: got int")
+	var cmd struct {
+		A **struct{}
+	}
+	err = ParseErr(&cmd, nil)
+	assert.True(t, errors.Is(err, ErrBadFieldType), "%v", err)
+	assert.False(t, errors.Is(err, ErrNotStruct))
+}
+
+func TestEmbeddedStructPrefix(t *testing.T) {
+	type server struct {
+		Addr string
+	}
+	type Listen struct {
+		Addr string
+	}
+	type cmd struct {
+		Server server
+		Srv    server `prefix:"s"`
+		Flat   server `prefix:""`
+		Listen `prefix:"l"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{
+			Server: server{"a"},
+			Srv:    server{"b"},
+			Flat:   server{"c"},
+			Listen: Listen{"d"},
+		}, "-server.addr=a", "-s.addr=b", "-addr=c", "-l.addr=d"),
+		errorMessageCase(`arg[0] "-srv.addr=b": unknown flag: "srv.addr"`, "-srv.addr=b"),
+	}, newStruct(cmd{}))
+}
+
+func TestPointerToSlice(t *testing.T) {
+	type cmd struct {
+		Tags *[]string
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{}),
+		noErrorCase(cmd{Tags: &[]string{"a", "b"}}, "-tags=a", "-tags=b"),
+	}, newStruct(cmd{}))
+}
+
+func TestMapFlags(t *testing.T) {
+	type cmd struct {
+		Weights map[string]float64 `name:"weight"`
+		Ports   map[int]string     `name:"port"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{}),
+		noErrorCase(cmd{
+			Weights: map[string]float64{"a": 1.5, "b": 2},
+			Ports:   map[int]string{80: "http"},
+		}, "-weight=a=1.5", "-weight=b=2", "-port=80=http"),
+		errorMessageCase(`arg[0] "-port=http=80": parsing value "http=80" for flag "port": parsing key "http": strconv.ParseInt: parsing "http": invalid syntax`, "-port=http=80"),
+		anyErrorCase("-weight=a=heavy"),
+		anyErrorCase("-weight=a"),
+	}, newStruct(cmd{}))
+}
+
+func TestUnknownFlagsAsPositional(t *testing.T) {
+	type cmd struct {
+		Verbose bool `name:"v"`
+		StartPos
+		Args []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Verbose: true, Args: []string{"-foo", "x", "-bar=1"}}, "-foo", "-v", "x", "-bar=1"),
+		errorIsCase(ErrDefaultHelp, "-h"),
+	}, newStruct(cmd{}), UnknownFlagsAsPositional())
+	type wrapperCmd struct {
+		Verbose bool `name:"v"`
+		StartPos
+		Command string
+		ExcessArgs
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(wrapperCmd{Verbose: true, Command: "-unknown", ExcessArgs: ExcessArgs{"-x", "y"}}, "-v", "-unknown", "-x", "y"),
+	}, newStruct(wrapperCmd{}), UnknownFlagsAsPositional())
+	type boundedCmd struct {
+		StartPos
+		Arg string
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(boundedCmd{Arg: "-foo"}, "-foo"),
+		anyErrorCase("-foo", "-bar"),
+	}, newStruct(boundedCmd{}), UnknownFlagsAsPositional())
+}
+
+func TestChoices(t *testing.T) {
+	type cmd struct {
+		Level string `choices:"debug,info"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Level: "info"}, "-level=info"),
+		errorMessageCase(`arg[0] "-level=trace": parsing value "trace" for flag "level": invalid value "trace": must be one of debug, info`, "-level=trace"),
+	}, newStruct(cmd{}))
+}
+
+func TestChoicesFold(t *testing.T) {
+	type cmd struct {
+		Level string   `choices:"debug,Info" choicesfold:"true"`
+		Exact string   `choices:"a,b"`
+		Tags  []string `choices:"x,y" choicesfold:"true"`
+		StartPos
+		Mode string `choices:"fast,slow" choicesfold:"true" arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Level: "Info"}, "-level=INFO"),
+		noErrorCase(cmd{Level: "debug", Tags: []string{"x", "y"}, Mode: "slow"}, "-level=Debug", "-tags=X", "-tags=y", "SLOW"),
+		errorMessageCase(`arg[0] "-level=trace": parsing value "trace" for flag "level": invalid value "trace": must be one of debug, Info`, "-level=trace"),
+		errorMessageCase(`arg[0] "-exact=A": parsing value "A" for flag "exact": invalid value "A": must be one of a, b`, "-exact=A"),
+	}, newStruct(cmd{}))
+}
+
+func TestPositionalBool(t *testing.T) {
+	type cmd struct {
+		StartPos
+		Enable bool
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Enable: true}, "true"),
+		noErrorCase(cmd{Enable: true}, "1"),
+		noErrorCase(cmd{Enable: false}, "0"),
+		noErrorCase(cmd{Enable: false}, "false"),
+		errorMessageCase(`arg[0] "": argument ENABLE: empty value for bool`, ""),
+		anyErrorCase("yes"),
+	}, newStruct(cmd{}))
+}
+
+func TestBoolEmptyValue(t *testing.T) {
+	type cmd struct {
+		Verbose bool
+		Debug   *bool
+	}
+	yes := true
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Verbose: true}, "-verbose="),
+		noErrorCase(cmd{Debug: &yes}, "-debug="),
+		noErrorCase(cmd{Verbose: false}, "-verbose=false"),
+	}, newStruct(cmd{}))
+	var b bool
+	require.NoError(t, Unmarshal("", &b))
+	assert.True(t, b)
+}
+
+func TestErrorArgIndex(t *testing.T) {
+	type cmd struct {
+		Count int
+		StartPos
+		Files []string
+	}
+	RunCases(t, []parseCase{
+		errorMessageCase(`arg[3] "-count=x": parsing value "x" for flag "count": can't convert "x" to int: invalid syntax`, "a", "-count=1", "b", "-count=x", "c"),
+		errorMessageCase(`arg[2] "-size=1": unknown flag: "size"`, "a", "b", "-size=1"),
+	}, newStruct(cmd{}))
+}
+
+func TestUniqueSlice(t *testing.T) {
+	type cmd struct {
+		Feature []string `unique:"true"`
+		Dup     []string
+		Set     map[string]struct{}
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{
+			Feature: []string{"x", "y", "z"},
+			Dup:     []string{"a", "a"},
+			Set:     map[string]struct{}{"p": {}, "q": {}},
+		}, "-feature=x", "-feature=y", "-feature=x", "-feature=z", "-feature=y",
+			"-dup=a", "-dup=a", "-set=p", "-set=q", "-set=p"),
+	}, newStruct(cmd{}))
+}
+
+func TestTimeFormats(t *testing.T) {
+	type cmd struct {
+		At     time.Time
+		Secs   time.Time `format:"unix"`
+		Millis time.Time `format:"unixmilli"`
+		Nanos  time.Time `format:"unixnano"`
+		Date   time.Time `format:"2006-01-02"`
+	}
+	var c cmd
+	require.NoError(t, ParseErr(&c, []string{
+		"-at=2023-11-14T22:13:20Z",
+		"-secs=1700000000",
+		"-millis=1700000000123",
+		"-nanos=1700000000000000001",
+		"-date=2023-11-14",
+	}))
+	assert.True(t, c.At.Equal(time.Unix(1700000000, 0)))
+	assert.True(t, c.Secs.Equal(time.Unix(1700000000, 0)))
+	assert.True(t, c.Millis.Equal(time.Unix(1700000000, 123e6)))
+	assert.True(t, c.Nanos.Equal(time.Unix(1700000000, 1)))
+	assert.True(t, c.Date.Equal(time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC)))
+	assert.Error(t, ParseErr(&c, []string{"-secs=yesterday"}))
+	assert.Error(t, ParseErr(&c, []string{"-millis=1.5"}))
+	// Beyond the range of time.Duration in nanoseconds.
+	require.NoError(t, ParseErr(&c, []string{"-secs=99999999999", "-millis=-99999999999999"}))
+	assert.EqualValues(t, 5138, c.Secs.UTC().Year())
+	assert.True(t, c.Secs.Equal(time.Unix(99999999999, 0)))
+	assert.EqualValues(t, -1199, c.Millis.UTC().Year())
+	assert.True(t, c.Millis.Equal(time.Unix(-99999999999, -999e6)))
+}
+
+func TestNormalizeURL(t *testing.T) {
+	var cmd struct {
+		URL *url.URL `normalize:"true"`
+		Raw *url.URL
+	}
+	for _, _case := range []struct {
+		in, expected string
+	}{
+		{"HTTP://Example.COM/", "http://example.com/"},
+		{"https://Example.com/a/B//", "https://example.com/a/B"},
+		{"https://example.com", "https://example.com"},
+		{"https://example.com/a?q=1", "https://example.com/a?q=1"},
+	} {
+		require.NoError(t, ParseErr(&cmd, []string{"-url=" + _case.in, "-raw=" + _case.in}))
+		assert.EqualValues(t, _case.expected, cmd.URL.String())
+	}
+	assert.EqualValues(t, "https://example.com/a?q=1", cmd.Raw.String())
+	require.NoError(t, ParseErr(&cmd, []string{"-raw=HTTP://Example.COM/a/"}))
+	assert.EqualValues(t, "http://Example.COM/a/", cmd.Raw.String())
+}
+
+// A lone - is assigned to positional fields as is, for the program to take as stdin.
+func TestStdinDash(t *testing.T) {
+	var cmd struct {
+		Verbose bool `name:"v"`
+		StartPos
+		Input string
+		Extra []string `arity:"*"`
+	}
+	require.NoError(t, ParseErr(&cmd, []string{"-", "-v"}))
+	assert.EqualValues(t, "-", cmd.Input)
+	assert.True(t, cmd.Verbose)
+	require.NoError(t, ParseErr(&cmd, []string{"--", "-", "a", "-"}))
+	assert.EqualValues(t, "-", cmd.Input)
+	assert.EqualValues(t, []string{"a", "-"}, cmd.Extra)
+}
+
+func TestInverseFlag(t *testing.T) {
+	type cmd struct {
+		Color bool `inverse:"no-color"`
+	}
+	newCmd := func() interface{} { return &cmd{Color: true} }
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Color: true}),
+		noErrorCase(cmd{Color: false}, "-no-color"),
+		noErrorCase(cmd{Color: true}, "-no-color", "-color"),
+		noErrorCase(cmd{Color: true}, "-no-color=false"),
+		noErrorCase(cmd{Color: false}, "-color=false"),
+	}, newCmd)
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...]
+Options:
+  -color      (bool)   (Default: true)
+  -no-color   (bool)   sets -color to false
+`, usageString(t, newCmd(), Program("prog")))
+	var bad struct {
+		Level int `inverse:"low"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+}
+
+func TestNargsFlag(t *testing.T) {
+	type cmd struct {
+		Ports   []int    `nargs:"+"`
+		Tags    []string `nargs:"*"`
+		Verbose bool     `name:"v"`
+		StartPos
+		Args []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Ports: []int{80, 443, 8080}, Verbose: true}, "-ports", "80", "443", "8080", "-v"),
+		noErrorCase(cmd{Ports: []int{1, 2, 3}}, "-ports=1", "2", "-ports", "3"),
+		noErrorCase(cmd{Ports: []int{1}, Args: []string{"a"}}, "-ports", "1", "--", "a"),
+		noErrorCase(cmd{Tags: []string{"-"}}, "-tags", "-"),
+		noErrorCase(cmd{Verbose: true}, "-tags", "-v"),
+		errorMessageCase(`arg[0] "-ports": flag "ports" requires at least one value`, "-ports", "-v"),
+		anyErrorCase("-ports", "80", "http"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Port int `nargs:"+"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+}
+
+func TestFlagNames(t *testing.T) {
+	type TLS struct {
+		CertFile string
+	}
+	var cmd struct {
+		Verbose bool `name:"v" inverse:"quiet"`
+		Server  struct {
+			ListenAddr string
+			TLS
+			Limits struct {
+				MaxConns int
+			} `prefix:"lim"`
+		}
+		StartPos
+		Input  string
+		Output []string
+	}
+	flags, err := FlagNames(&cmd)
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"quiet", "server.certFile", "server.lim.maxConns", "server.listenAddr", "v"}, flags)
+	pos, err := PositionalNames(&cmd)
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"INPUT", "OUTPUT"}, pos)
+	_, err = FlagNames(new(int))
+	assert.Error(t, err)
+}
+
+type switchCounter struct {
+	n int
+}
+
+func (me *switchCounter) Trigger() {
+	me.n++
+}
+
+func TestTriggererFlag(t *testing.T) {
+	type cmd struct {
+		Bump switchCounter `arity:"0"`
+		Poke switchCounter
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{}),
+		noErrorCase(cmd{Bump: switchCounter{2}, Poke: switchCounter{1}}, "-bump", "-poke", "-bump"),
+		errorMessageCase(`arg[0] "-bump=1": flag "bump" takes no value`, "-bump=1"),
+		anyErrorCase("-poke=1"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Level int `arity:"0"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+}
+
+func TestJSONFlags(t *testing.T) {
+	type point struct {
+		X, Y int
+	}
+	var cmd struct {
+		Point point `encoding:"json"`
+		Raw   json.RawMessage
+		Extra map[string]interface{}
+	}
+	require.NoError(t, ParseErr(&cmd, []string{`-point={"X":1,"Y":2}`, `-raw={"a": [1]}`, `-extra={"a":1}`}))
+	assert.EqualValues(t, point{1, 2}, cmd.Point)
+	assert.EqualValues(t, `{"a": [1]}`, string(cmd.Raw))
+	assert.EqualValues(t, map[string]interface{}{"a": 1.0}, cmd.Extra)
+	err := ParseErr(&cmd, []string{`-raw={"a":`})
+	assert.EqualError(t, err, `arg[0] "-raw={\"a\":": parsing value "{\"a\":" for flag "raw": unexpected end of JSON input`)
+	assert.Error(t, ParseErr(&cmd, []string{`-point=[1]`}))
+	assert.Error(t, ParseErr(&cmd, []string{`-point`}))
+}
+
+func TestAutoShort(t *testing.T) {
+	type cmd struct {
+		Workers int
+		Watch   bool
+		Verbose bool `name:"v"`
+		Host    string
+		Level   int
+		L       bool
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Workers: 3, Watch: true, Verbose: true}, "-w=3", "-watch", "-v"),
+		noErrorCase(cmd{L: true}, "-l"),
+		errorIsCase(ErrDefaultHelp, "-h"),
+	}, newStruct(cmd{}), AutoShort())
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...]
+Options:
+  -host          (string)   
+  -l             (bool)     
+  -level         (int)      
+  -v             (bool)     
+  -watch         (bool)     
+  -w, -workers   (int)      
+`, usageString(t, &cmd{}, Program("prog"), AutoShort()))
+}
+
+func TestCountFlags(t *testing.T) {
+	type cmd struct {
+		Verbosity int `count:"v" countdown:"q"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{}),
+		noErrorCase(cmd{Verbosity: 1}, "-v", "-v", "-q"),
+		noErrorCase(cmd{Verbosity: -2}, "-q", "-q"),
+		noErrorCase(cmd{Verbosity: 4}, "-verbosity=3", "-v"),
+		errorMessageCase(`arg[0] "-v=2": flag "v" takes no value`, "-v=2"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Verbose bool `count:"v"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+	var dup struct {
+		Verbosity int `count:"v" countdown:"v"`
+	}
+	assert.Error(t, ParseErr(&dup, nil))
+}
+
+// A path that must be absolute as a flag value, but may be relative as a positional argument.
+type contextPath string
+
+func (me *contextPath) Marshal(s string) error {
+	*me = contextPath(s)
+	return nil
+}
+
+func (*contextPath) RequiresExplicitValue() bool {
+	return true
+}
+
+func (me *contextPath) MarshalPositional(s string) error {
+	*me = contextPath(filepath.Join("/base", s))
+	return nil
+}
+
+func (me *contextPath) MarshalFlag(s string) error {
+	if !filepath.IsAbs(s) {
+		return errors.New("must be absolute")
+	}
+	*me = contextPath(s)
+	return nil
+}
+
+func TestContextSensitiveMarshaling(t *testing.T) {
+	type cmd struct {
+		Out contextPath
+		StartPos
+		In contextPath
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Out: "/tmp/out", In: "/base/in"}, "-out=/tmp/out", "in"),
+		anyErrorCase("-out=out", "in"),
+	}, newStruct(cmd{}))
+	var c contextPath
+	require.NoError(t, Unmarshal("rel", &c))
+	assert.EqualValues(t, "rel", c)
+}
+
+func TestAfterParse(t *testing.T) {
+	type cmd struct {
+		Min, Max int
+		StartPos
+		Name string
+	}
+	errRange := errors.New("min exceeds max")
+	var seen cmd
+	validate := AfterParse(func(i interface{}) error {
+		c := i.(*cmd)
+		seen = *c
+		if c.Min > c.Max {
+			return errRange
+		}
+		return nil
+	})
+	var c cmd
+	require.NoError(t, ParseErr(&c, []string{"-min=1", "-max=2", "x"}, validate))
+	assert.EqualValues(t, cmd{Min: 1, Max: 2, Name: "x"}, seen)
+	assert.Equal(t, errRange, ParseErr(&c, []string{"-min=3", "x"}, validate))
+	seen = cmd{}
+	assert.Error(t, ParseErr(&c, []string{"-min=3"}, validate))
+	assert.EqualValues(t, cmd{}, seen)
+}
+
+func TestKeyValuePositionals(t *testing.T) {
+	type cmd struct {
+		Name string
+		Age  int
+		StartPos
+		Args []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Name: "foo", Age: 30, Args: []string{"a", "other=1", "b"}}, "name=foo", "a", "other=1", "age=30", "b"),
+		noErrorCase(cmd{Args: []string{"name=foo"}}, "--", "name=foo"),
+		anyErrorCase("age=old"),
+	}, newStruct(cmd{}), KeyValuePositionals())
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Args: []string{"name=foo"}}, "name=foo"),
+	}, newStruct(cmd{}))
+}
+
+func TestExcessArgError(t *testing.T) {
+	type cmd struct {
+		StartPos
+		A string
+		B string `arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		errorIsCase(userError{`excess argument "c": at most 2 positional arguments accepted`}, "a", "b", "c"),
+	}, newStruct(cmd{}))
+	var variadic struct {
+		StartPos
+		Files []string
+	}
+	var ue userError
+	require.True(t, errors.As(ParseErr(&variadic, make([]string, infArity+1)), &ue))
+	assert.EqualValues(t, `excess argument "": too many values for variadic FILES`, ue.msg)
+}
+
+func TestSetPathFlag(t *testing.T) {
+	type server struct {
+		Addr string
+		TLS  struct {
+			Cert string
+		}
+	}
+	type cmd struct {
+		Server server
+		Debug  bool
+	}
+	var c cmd
+	require.NoError(t, ParseErr(&c, []string{"-set", "server.addr=:80", "-set=server.tls.cert=a.pem", "-set", "debug=true"}, SetPathFlag("set")))
+	assert.EqualValues(t, ":80", c.Server.Addr)
+	assert.EqualValues(t, "a.pem", c.Server.TLS.Cert)
+	assert.True(t, c.Debug)
+	RunCases(t, []parseCase{
+		errorIsCase(userError{`unknown flag: "server.adr" (did you mean "server.addr"?)`}, "-set", "server.adr=:80"),
+		errorIsCase(userError{`flag "set" needs a value of the form path=value, got "server.addr"`}, "-set", "server.addr"),
+		errorIsCase(userError{`flag "set" needs a value of the form path=value`}, "-set"),
+	}, newStruct(cmd{}), SetPathFlag("set"))
+	var conflict struct {
+		Set string
+	}
+	assert.Error(t, ParseErr(&conflict, nil, SetPathFlag("set")))
+}
+
+func TestByteSlices(t *testing.T) {
+	type B []byte
+	var cmd struct {
+		Raw   []byte
+		Named B
+		Hex   B `encoding:"hex"`
+		Bs    []int
+		StartPos
+		Pos  []byte
+		Rest []string `arity:"*"`
+	}
+	require.NoError(t, ParseErr(&cmd, []string{"-raw=hello", "-named=world", "-hex=00ff", "-bs=1", "-bs=2", "pos", "x"}))
+	assert.EqualValues(t, []byte("hello"), cmd.Raw)
+	assert.EqualValues(t, B("world"), cmd.Named)
+	assert.EqualValues(t, B{0, 0xff}, cmd.Hex)
+	assert.EqualValues(t, []int{1, 2}, cmd.Bs)
+	assert.EqualValues(t, []byte("pos"), cmd.Pos)
+	assert.EqualValues(t, []string{"x"}, cmd.Rest)
+	err := ParseErr(&cmd, []string{"-hex=zz", "pos"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "decoding hex")
+}
+
+func TestExperimentalFlag(t *testing.T) {
+	type cmd struct {
+		NewEngine bool `experimental:"true"`
+		Workers   int
+	}
+	defer unsetenv("MY_APP_EXPERIMENTAL")()
+	RunCases(t, []parseCase{
+		errorIsCase(userError{"experimental flag, set MY_APP_EXPERIMENTAL=1 to enable"}, "-newEngine"),
+		noErrorCase(cmd{Workers: 1}, "-workers=1"),
+	}, newStruct(cmd{}), Program("my-app"))
+	RunCases(t, []parseCase{
+		errorIsCase(userError{"experimental flag, set ENGINE_EXPERIMENTAL=1 to enable"}, "-newEngine"),
+	}, newStruct(cmd{}), ExperimentalEnv("ENGINE_EXPERIMENTAL"))
+	assert.NotContains(t, usageString(t, &cmd{}, Program("my-app")), "newEngine")
+	defer setenv("MY_APP_EXPERIMENTAL", "1")()
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{NewEngine: true}, "-newEngine"),
+	}, newStruct(cmd{}), Program("my-app"))
+	assert.Contains(t, usageString(t, &cmd{}, Program("my-app")), "-newEngine")
+}
+
+func TestNumPositional(t *testing.T) {
+	var cmd struct {
+		Verbose bool
+		StartPos
+		Cmd   string
+		Files []string `arity:"+"`
+		Out   string   `arity:"?"`
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, p.NumPositional())
+	require.NoError(t, p.Parse([]string{"cp", "a", "-verbose", "", "c"}))
+	assert.EqualValues(t, 4, p.NumPositional())
+	assert.EqualValues(t, []string{"a", "", "c"}, cmd.Files)
+}
+
+type rawArgsCmd struct {
+	Verbose bool
+	args    []string
+}
+
+func (me *rawArgsCmd) ParseArgs(args []string) error {
+	me.args = args
+	if len(args) == 0 {
+		return errors.New("no args")
+	}
+	return nil
+}
+
+func TestArgsParser(t *testing.T) {
+	var cmd rawArgsCmd
+	args := []string{"-verbose", "--", "-x", "y"}
+	require.NoError(t, ParseErr(&cmd, args))
+	assert.EqualValues(t, args, cmd.args)
+	assert.False(t, cmd.Verbose)
+	assert.EqualError(t, ParseErr(&cmd, nil), "no args")
+}
+
+func TestRequiredFlags(t *testing.T) {
+	type cmd struct {
+		APIKey string `required:"true"`
+		Region string `required:"true"`
+	}
+	RunCases(t, []parseCase{
+		errorIsCase(userError{`missing required flag: "apiKey"`}),
+		errorIsCase(userError{`missing required flag: "region"`}, "-apiKey=k"),
+		noErrorCase(cmd{APIKey: "k", Region: "r"}, "-region=r", "-apiKey=k"),
+	}, newStruct(cmd{}))
+	var c cmd
+	require.NoError(t, ParseErr(&c, []string{"-apiKey=k"}, Source(func(name string) (string, bool) {
+		return "us", name == "region"
+	})))
+	assert.EqualValues(t, "us", c.Region)
+}
+
+func TestDoubleDashValues(t *testing.T) {
+	type cmd struct {
+		Sep string
+		StartPos
+		Args []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Sep: "--"}, "-sep=--"),
+		noErrorCase(cmd{Sep: "--", Args: []string{"a"}}, "-sep=--", "a"),
+		noErrorCase(cmd{Args: []string{"--"}}, "--", "--"),
+		noErrorCase(cmd{Sep: "x", Args: []string{"-sep=y", "--", "b"}}, "-sep=x", "--", "-sep=y", "--", "b"),
+	}, newStruct(cmd{}))
+}
+
+func TestIPv6Zone(t *testing.T) {
+	var cmd struct {
+		IP     net.IP
+		IPAddr net.IPAddr
+		Addr   *net.TCPAddr
+	}
+	require.NoError(t, ParseErr(&cmd, []string{"-ip=fe80::1%eth0", "-ipAddr=fe80::1%eth0", "-addr=[fe80::1%eth0]:80"}))
+	assert.EqualValues(t, "fe80::1", cmd.IP.String())
+	assert.EqualValues(t, "fe80::1%eth0", cmd.IPAddr.String())
+	assert.EqualValues(t, "eth0", cmd.Addr.Zone)
+	assert.EqualValues(t, 80, cmd.Addr.Port)
+	assert.True(t, cmd.Addr.IP.IsLinkLocalUnicast())
+	assert.Error(t, ParseErr(&cmd, []string{"-ip=%eth0"}))
+	assert.Error(t, ParseErr(&cmd, []string{"-ipAddr=nope%eth0"}))
+}
+
+func TestRate(t *testing.T) {
+	var cmd struct {
+		Limit Rate
+	}
+	for _, c := range []struct {
+		arg         string
+		bytesPerSec float64
+	}{
+		{"10MB/s", 10e6},
+		{"10MiB/s", 10 << 20},
+		{"100Mbps", 12.5e6},
+		{"1Gbit/s", 125e6},
+		{"8kb/s", 1000},
+		{"100 B/s", 100},
+	} {
+		require.NoError(t, ParseErr(&cmd, []string{"-limit=" + c.arg}), c.arg)
+		assert.EqualValues(t, c.bytesPerSec, cmd.Limit.BytesPerSec(), c.arg)
+		assert.EqualValues(t, 8*c.bytesPerSec, cmd.Limit.BitsPerSec(), c.arg)
+	}
+	assert.EqualValues(t, "10 MB/s", Rate(10e6).String())
+	assert.Error(t, ParseErr(&cmd, []string{"-limit=10MB"}))
+	assert.Error(t, ParseErr(&cmd, []string{"-limit=10M/s"}))
+	assert.Error(t, ParseErr(&cmd, []string{"-limit=fastB/s"}))
+}
+
+func TestPercent(t *testing.T) {
+	type cmd struct {
+		Threshold Percent
+		Ratio     Percent `max:"100%"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Threshold: 0.75}, "-threshold=75%"),
+		noErrorCase(cmd{Threshold: 0.75}, "-threshold=0.75"),
+		noErrorCase(cmd{Ratio: 1}, "-ratio=1"),
+		errorIsCase(userError{"value 120% is greater than maximum 100%"}, "-ratio=120%"),
+		anyErrorCase("-threshold=lots%"),
+	}, newStruct(cmd{}))
+	assert.EqualValues(t, "75%", Percent(0.75).String())
+	assert.EqualValues(t, "10%", Percent(0.1).String())
+}
+
+func TestMinMaxTags(t *testing.T) {
+	type cmd struct {
+		Workers int           `min:"1" max:"8"`
+		Timeout time.Duration `max:"1m"`
+		Ratio   float64       `min:"0"`
+		StartPos
+		Port uint `min:"1024" arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Workers: 8, Timeout: time.Minute}, "-workers=8", "-timeout=1m"),
+		errorIsCase(userError{"value 0 is less than minimum 1"}, "-workers=0"),
+		errorIsCase(userError{"value 9 is greater than maximum 8"}, "-workers=9"),
+		errorIsCase(userError{"value 1m1s is greater than maximum 1m0s"}, "-timeout=61s"),
+		errorIsCase(userError{"value -0.5 is less than minimum 0"}, "-ratio=-0.5"),
+		errorIsCase(userError{"value 80 is less than minimum 1024"}, "80"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Name string `max:"3"`
+	}
+	_, err := NewParser(&bad)
+	assert.Error(t, err)
+	var badBound struct {
+		N int `max:"many"`
+	}
+	_, err = NewParser(&badBound)
+	assert.Error(t, err)
+}
+
+func TestArgsSource(t *testing.T) {
+	defer func(orig func() []string) { ArgsSource = orig }(ArgsSource)
+	ArgsSource = func() []string { return []string{"-workers=3", "in"} }
+	var cmd struct {
+		Workers int
+		StartPos
+		Input string
+	}
+	Parse(&cmd)
+	assert.EqualValues(t, 3, cmd.Workers)
+	assert.EqualValues(t, "in", cmd.Input)
+}
+
+func TestEmptyFlagName(t *testing.T) {
+	type cmd struct {
+		Addr string
+	}
+	RunCases(t, []parseCase{
+		errorIsCase(userError{`empty flag name in "-=foo"`}, "-=foo"),
+		errorIsCase(userError{`empty flag name in "--=bar"`}, "--=bar"),
+		errorMessageCase(`arg[1] "-=": empty flag name in "-="`, "-addr=x", "-="),
+	}, newStruct(cmd{}))
+}
+
+func TestMaxArgs(t *testing.T) {
+	type cmd struct {
+		Verbose bool
+		StartPos
+		Files []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Verbose: true, Files: []string{"a", "b"}}, "-verbose", "a", "b"),
+		errorIsCase(userError{"too many arguments: 4 given, at most 3 accepted"}, "-verbose", "a", "b", "c"),
+	}, newStruct(cmd{}), MaxArgs(3))
+	// Flags that take their value from the next argument don't get around the cap.
+	var long struct {
+		A, B string
+	}
+	assert.EqualValues(t, userError{"too many arguments: 4 given, at most 3 accepted"},
+		ParseErr(&long, []string{"--a", "1", "--b", "2"}, MaxArgs(3)))
+	// Nothing is assigned before the error.
+	assert.Empty(t, long.A)
+}
+
+func TestLongFlags(t *testing.T) {
+	type cmd struct {
+		ListenAddr string
+		Verbose    bool
+		Size       Bytes
+		StartPos
+		Args []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{ListenAddr: "1.2.3.4"}, "--listenAddr=1.2.3.4"),
+		noErrorCase(cmd{ListenAddr: "1.2.3.4"}, "--listenAddr", "1.2.3.4"),
+		noErrorCase(cmd{ListenAddr: "x", Args: []string{"a"}}, "--listenAddr", "x", "a"),
+		noErrorCase(cmd{Verbose: true, Args: []string{"false"}}, "--verbose", "false"),
+		noErrorCase(cmd{Size: 1e6, Verbose: true}, "--size", "1MB", "--verbose"),
+		errorIsCase(userError{`explicit value required (-listenAddr=VALUE)`}, "--listenAddr", "--verbose"),
+		errorIsCase(userError{`explicit value required (-listenAddr=VALUE)`}, "--listenAddr"),
+		// The separate value form is only for long flags.
+		errorIsCase(userError{`explicit value required (-listenAddr=VALUE)`}, "-listenAddr", "x"),
+		errorIsCase(userError{`unknown flag: "nope"`}, "--nope", "x"),
+	}, newStruct(cmd{}))
+}
+
+func TestSliceOfStructs(t *testing.T) {
+	type peer struct {
+		Host string
+		Port int
+		TLS  bool `name:"tls"`
+	}
+	type cmd struct {
+		Peer []peer
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Peer: []peer{{Host: "a", Port: 1}, {Host: "b", TLS: true}}}, "-peer=host=a,port=1", "--peer", "host=b,tls=true"),
+		errorMessageCase(`arg[0] "-peer=host=a,addr=x": parsing value "host=a,addr=x" for flag "peer": unknown field "addr", expected one of host, port, tls`, "-peer=host=a,addr=x"),
+		anyErrorCase("-peer=host"),
+		anyErrorCase("-peer=port=x"),
+	}, newStruct(cmd{}))
+}
+
+func TestNameFunc(t *testing.T) {
+	var cmd struct {
+		ListenAddr string
+		HTTPServer struct {
+			MaxConns int
+		}
+		Verbose bool `name:"V"`
+	}
+	names, err := FlagNames(&cmd, NameFunc(xstrings.ToSnakeCase))
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"V", "http_server.max_conns", "listen_addr"}, names)
+	require.NoError(t, ParseErr(&cmd, []string{"-listen_addr=:80", "-http_server.max_conns=3"}, NameFunc(xstrings.ToSnakeCase)))
+	assert.EqualValues(t, ":80", cmd.ListenAddr)
+	assert.EqualValues(t, 3, cmd.HTTPServer.MaxConns)
+}
+
+func TestOptionalPositionalDefault(t *testing.T) {
+	type cmd struct {
+		StartPos
+		Input  string
+		Output string   `arity:"?" default:"out.txt"`
+		Extra  []string `arity:"*" default:"x"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Input: "in", Output: "out.txt", Extra: []string{"x"}}, "in"),
+		noErrorCase(cmd{Input: "in", Output: "o", Extra: []string{"x"}}, "in", "o"),
+		noErrorCase(cmd{Input: "in", Output: "o", Extra: []string{"a", "b"}}, "in", "o", "a", "b"),
+	}, newStruct(cmd{}))
+	var c cmd
+	p, err := NewParser(&c)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"in"}))
+	require.NoError(t, p.ApplyDefaults())
+	assert.EqualValues(t, []string{"x"}, c.Extra)
+	assert.False(t, p.IsSet("OUTPUT"))
+	var bad struct {
+		StartPos
+		N int `arity:"?" default:"many"`
+	}
+	_, err = NewParser(&bad)
+	assert.Error(t, err)
+}
+
+func TestIntermixedVariadic(t *testing.T) {
+	type cmd struct {
+		X bool
+		StartPos
+		Args []string
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{X: true, Args: []string{"a", "b", "c"}}, "a", "-x", "b", "c"),
+		noErrorCase(cmd{X: true, Args: []string{"a", "b", "c"}}, "a", "b", "c", "-x"),
+		noErrorCase(cmd{Args: []string{"a", "-x", "b"}}, "a", "--", "-x", "b"),
+	}, newStruct(cmd{}))
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Args: []string{"a", "-x", "b", "c"}}, "a", "-x", "b", "c"),
+		noErrorCase(cmd{X: true, Args: []string{"a", "b", "c"}}, "-x", "a", "b", "c"),
+	}, newStruct(cmd{}), ParseIntermixed(false))
+}
+
+func TestDeprecatedReplacedBy(t *testing.T) {
+	var cmd struct {
+		ListenAddr string
+		Addr       string `deprecated:"true" replacedby:"listenAddr"`
+		Old        bool   `deprecated:"true"`
+	}
+	var warnings bytes.Buffer
+	require.NoError(t, ParseErr(&cmd, []string{"-addr=:80", "-old"}, WarningOutput(&warnings)))
+	assert.EqualValues(t, ":80", cmd.ListenAddr)
+	assert.EqualValues(t, "", cmd.Addr)
+	assert.True(t, cmd.Old)
+	assert.Equal(t, `tagflag: warning: flag "addr" is deprecated, use "listenAddr"
+tagflag: warning: flag "old" is deprecated
+`, warnings.String())
+	var bad struct {
+		Addr string `deprecated:"true" replacedby:"listen-addr"`
+	}
+	_, err := NewParser(&bad)
+	assert.EqualError(t, err, `flag "addr" is replaced by unknown flag "listen-addr"`)
+}
+
+func TestWalkFields(t *testing.T) {
+	var cmd struct {
+		Verbose   bool `inverse:"quiet"`
+		Verbosity int  `count:"v"`
+		Server    struct {
+			Addr string `help:"listen address"`
+		}
+		StartPos
+		Input string
+		Files []string
+	}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	require.NoError(t, p.Func("walk", "", func(string) error { return nil }))
+	var visited []string
+	p.WalkFields(func(name string, value reflect.Value, tag reflect.StructTag, positional bool) {
+		visited = append(visited, fmt.Sprintf("%s %s %v", name, value.Type(), positional))
+		if name == "server.addr" {
+			assert.EqualValues(t, "listen address", tag.Get("help"))
+			value.SetString(":80")
+		}
+	})
+	assert.EqualValues(t, []string{
+		"verbose bool false",
+		"verbosity int false",
+		"server.addr string false",
+		"INPUT string true",
+		"FILES []string true",
+	}, visited)
+	assert.EqualValues(t, ":80", cmd.Server.Addr)
+}
+
+func TestConversionErrors(t *testing.T) {
+	type count int
+	type cmd struct {
+		Count count
+		Limit *int
+		Debug bool
+		StartPos
+		N uint `arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		errorMessageCase(`arg[0] "-count=x": parsing value "x" for flag "count": can't convert "x" to github.com/anacrolix/tagflag.count: invalid syntax`, "-count=x"),
+		errorMessageCase(`arg[0] "-limit=1.5": parsing value "1.5" for flag "limit": can't convert "1.5" to *int: invalid syntax`, "-limit=1.5"),
+		errorMessageCase(`arg[0] "-debug=maybe": parsing value "maybe" for flag "debug": can't convert "maybe" to bool: invalid syntax`, "-debug=maybe"),
+		errorMessageCase(`arg[1] "-1": argument N: can't convert "-1" to uint: invalid syntax`, "--", "-1"),
+		errorIsCase(userError{`argument N: can't convert "99999999999999999999" to uint: value out of range`}, "99999999999999999999"),
+	}, newStruct(cmd{}))
+	err := ParseErr(&cmd{}, []string{"-limit=1.5"})
+	var ne *strconv.NumError
+	require.True(t, xerrors.As(err, &ne))
+	assert.Equal(t, strconv.ErrSyntax, ne.Err)
+	assert.EqualValues(t, "1.5", ne.Num)
+	// It's still a mistake by the user, for ParseArgs.
+	var ue userError
+	assert.True(t, xerrors.As(err, &ue))
+}
+
+func TestSubcommand(t *testing.T) {
+	type cmd struct {
+		Verbose bool `name:"v"`
+		Subcommand
+		Command string
+		ExcessArgs
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Verbose: true, Command: "serve", ExcessArgs: ExcessArgs{"-addr=:80", "x", "-v"}}, "-v", "serve", "-addr=:80", "x", "-v"),
+		noErrorCase(cmd{Command: "status"}, "status"),
+		errorIsCase(userError{`missing argument: "COMMAND"`}, "-v"),
+	}, newStruct(cmd{}))
+	var noExcess struct {
+		Subcommand
+		Command string
+	}
+	assert.Error(t, ParseErr(&noExcess, nil))
+	var twoPos struct {
+		Subcommand
+		Command string
+		Other   string
+		ExcessArgs
+	}
+	assert.Error(t, ParseErr(&twoPos, nil))
+}
+
+func TestUseJSONTags(t *testing.T) {
+	var cmd struct {
+		ListenAddr string `json:"listen_addr,omitempty"`
+		Workers    int    `json:",omitempty"`
+		Internal   string `json:"-"`
+		Debug      bool   `json:"debug_mode" name:"d"`
+		TLS        struct {
+			CertFile string `json:"cert_file"`
+		} `json:"tls"`
+	}
+	names, err := FlagNames(&cmd, UseJSONTags())
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"d", "internal", "listen_addr", "tls.cert_file", "workers"}, names)
+	names, err = FlagNames(&cmd)
+	require.NoError(t, err)
+	assert.EqualValues(t, []string{"d", "internal", "listenAddr", "tls.certFile", "workers"}, names)
+	require.NoError(t, ParseErr(&cmd, []string{"-listen_addr=:80", "-tls.cert_file=c.pem"}, UseJSONTags()))
+	assert.EqualValues(t, ":80", cmd.ListenAddr)
+	assert.EqualValues(t, "c.pem", cmd.TLS.CertFile)
+}
+
+func TestClampTag(t *testing.T) {
+	type cmd struct {
+		Workers int     `min:"1" max:"8" clamp:"true"`
+		Ratio   Percent `max:"100%" clamp:"true"`
+	}
+	var warnings bytes.Buffer
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Workers: 8}, "-workers=20"),
+		noErrorCase(cmd{Workers: 1}, "-workers=-3"),
+		noErrorCase(cmd{Workers: 5}, "-workers=5"),
+		noErrorCase(cmd{Ratio: 1}, "-ratio=150%"),
+	}, newStruct(cmd{}), WarningOutput(&warnings))
+	assert.Equal(t, `tagflag: warning: value 20 for "workers" is out of range, using 8
+tagflag: warning: value -3 for "workers" is out of range, using 1
+tagflag: warning: value 150% for "ratio" is out of range, using 100%
+`, warnings.String())
+	var secret struct {
+		N int `max:"5" clamp:"true" secret:"true"`
+	}
+	warnings.Reset()
+	require.NoError(t, ParseErr(&secret, []string{"-n=99"}, WarningOutput(&warnings)))
+	assert.EqualValues(t, 5, secret.N)
+	assert.Equal(t, "tagflag: warning: value *** for \"n\" is out of range, using ***\n", warnings.String())
+}
+
+func TestPositionalsVerbatim(t *testing.T) {
+	type cmd struct {
+		Verbose bool
+		StartPos
+		First string
+		Rest  []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{First: "hello, world", Rest: []string{" padded ", "a\tb\nc"}}, "hello, world", " padded ", "a\tb\nc"),
+		noErrorCase(cmd{First: "k=v", Rest: []string{"a=b=c", "=", ""}}, "k=v", "a=b=c", "=", ""),
+		noErrorCase(cmd{Verbose: true, First: "-verbose", Rest: []string{"--x=1", "--", "-"}}, "-verbose", "--", "-verbose", "--x=1", "--", "-"),
+		noErrorCase(cmd{First: `"quoted"`, Rest: []string{`'single'`, `\`, "$HOME", "*"}}, `"quoted"`, `'single'`, `\`, "$HOME", "*"),
+	}, newStruct(cmd{}))
+	// Options that split or interpret flag values don't apply to positionals.
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{First: "a,b", Rest: []string{"verbose=true", "c,,d"}}, "a,b", "verbose=true", "c,,d"),
+	}, newStruct(cmd{}), SplitSlices(","))
+}
+
+func TestSoftHelp(t *testing.T) {
+	var cmd struct {
+		Verbose bool `help:"print more"`
+		StartPos
+		Name string
+	}
+	var usage string
+	p, err := NewParser(&cmd, Program("repl"), SoftHelp(func(u string) { usage = u }))
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-verbose", "-help", "-bad"}))
+	assert.True(t, p.HelpShown())
+	assert.True(t, cmd.Verbose)
+	var want bytes.Buffer
+	p.WriteUsage(&want)
+	assert.Equal(t, want.String(), usage)
+	assert.Contains(t, usage, "print more")
+
+	usage = ""
+	p, err = NewParser(&cmd, SoftHelp(func(u string) { usage = u }))
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"bob"}))
+	assert.False(t, p.HelpShown())
+	assert.Empty(t, usage)
+
+	p, err = NewParser(&cmd, SoftHelp(func(string) { t.Fatal("help shown") }), NoDefaultHelp())
+	require.NoError(t, err)
+	assert.Error(t, p.Parse([]string{"-h"}))
+}
+
+func TestBracketList(t *testing.T) {
+	type cmd struct {
+		Tags  []string `list:"bracket"`
+		Ports []int    `list:"bracket"`
+		Plain []string
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Tags: []string{"a", "b", "c"}}, "-tags=[a,b,c]"),
+		noErrorCase(cmd{Tags: []string{"a", "b", "c", "d"}}, "-tags=[a,b]", "-tags=c", "-tags=[d]"),
+		noErrorCase(cmd{Tags: []string{"a", "b c"}, Ports: []int{80, 443}}, "-tags= [ a , b c ] ", "-ports=[80, 443]"),
+		noErrorCase(cmd{}, "-tags=[]", "-ports=[ ]"),
+		noErrorCase(cmd{Plain: []string{"[a,b]"}}, "-plain=[a,b]"),
+		errorMessageCase(`arg[0] "-tags=[a,b": parsing value "[a,b" for flag "tags": list "[a,b" is missing closing bracket`, "-tags=[a,b"),
+		anyErrorCase("-ports=[80,https]"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Tag string `list:"bracket"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+}
+
+func TestPathTag(t *testing.T) {
+	type cmd struct {
+		Config  string   `path:"true"`
+		Include []string `path:"true"`
+		Name    string
+		StartPos
+		Out string `path:"true" arity:"?"`
+	}
+	base := filepath.FromSlash("/srv/app")
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Config: filepath.Join(base, "conf/app.toml")}, "-config=conf/./app.toml"),
+		noErrorCase(cmd{Config: filepath.Join(base, "..", "etc")}, "-config=../etc"),
+		noErrorCase(cmd{Config: filepath.FromSlash("/etc/app.toml")}, "-config=/etc//app.toml"),
+		noErrorCase(cmd{Include: []string{filepath.Join(base, "a"), filepath.FromSlash("/b")}, Name: "c"}, "-include=a", "-include=/b", "-name=c"),
+		noErrorCase(cmd{Out: filepath.Join(base, "out")}, "out"),
+		noErrorCase(cmd{Config: ""}, "-config="),
+	}, newStruct(cmd{}), BasePath(base))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Config: filepath.Join(wd, "app.toml")}, "-config=app.toml"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Port int `path:"true"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+}
+
+func TestAlias(t *testing.T) {
+	type cmd struct {
+		ListenAddr    string
+		ListenEnabled bool
+		Verbose       bool
+		StartPos
+		Args []string `arity:"*"`
+	}
+	opt := Alias("listen", []string{"-listenAddr=:80", "-listenEnabled"})
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{ListenAddr: ":80", ListenEnabled: true}, "-listen"),
+		noErrorCase(cmd{ListenAddr: ":80", ListenEnabled: true, Verbose: true, Args: []string{"a"}}, "a", "--listen", "-verbose"),
+		noErrorCase(cmd{ListenAddr: ":81", ListenEnabled: true}, "-listen", "-listenAddr=:81"),
+		noErrorCase(cmd{Args: []string{"-listen"}}, "--", "-listen"),
+		errorMessageCase(`arg[0] "-listen=x": unknown flag: "listen"`, "-listen=x"),
+		// Errors give the index in the arguments as given, and the expansion doesn't count
+		// towards MaxArgs.
+		errorMessageCase(`arg[1] "-bogus": unknown flag: "bogus"`, "-listen", "-bogus"),
+		noErrorCase(cmd{ListenAddr: ":80", ListenEnabled: true, Args: []string{"a", "b"}}, "-listen", "a", "b"),
+	}, newStruct(cmd{}), opt, MaxArgs(3))
+	RunCases(t, []parseCase{
+		errorMessageCase(`arg[0] "-verbose=maybe": parsing value "maybe" for flag "verbose": can't convert "maybe" to bool: invalid syntax`, "-v"),
+		// The expansion takes its value from the argument after the alias.
+		noErrorCase(cmd{ListenAddr: ":90"}, "-a", ":90"),
+		errorMessageCase(`arg[2] "-bogus": unknown flag: "bogus"`, "-a", ":90", "-bogus"),
+	}, newStruct(cmd{}), Alias("v", []string{"-verbose=maybe"}), Alias("a", []string{"--listenAddr"}))
+	_, err := NewParser(&cmd{}, Alias("verbose", []string{"-listen"}))
+	assert.Error(t, err)
+	_, err = NewParser(&cmd{}, opt, Alias("l", []string{"--listen"}))
+	assert.Error(t, err)
+}
+
+func TestNoDuplicateFlags(t *testing.T) {
+	type cmd struct {
+		Name    string
+		Debug   bool `inverse:"quiet"`
+		Level   int  `count:"v"`
+		Tag     []string
+		Label   map[string]string
+		Maybe   *bool
+		Payload []byte
+	}
+	yes := true
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Name: "a", Tag: []string{"x", "y"}, Level: 2, Label: map[string]string{"k": "1", "j": "2"}}, "-name=a", "-tag=x", "-v", "-tag=y", "-v", "-label=k=1", "-label=j=2"),
+		errorMessageCase(`arg[1] "-name=b": flag "name" given more than once`, "-name=a", "-name=b"),
+		errorMessageCase(`arg[1] "-quiet": flag "debug" given more than once`, "-debug", "-quiet"),
+		errorMessageCase(`arg[1] "-no-maybe": flag "maybe" given more than once`, "-maybe", "-no-maybe"),
+		errorMessageCase(`arg[1] "-payload=b": flag "payload" given more than once`, "-payload=a", "-payload=b"),
+		noErrorCase(cmd{Maybe: &yes, Payload: []byte("p")}, "-maybe", "-payload=p"),
+	}, newStruct(cmd{}), NoDuplicateFlags())
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Name: "b"}, "-name=a", "-name=b"),
+	}, newStruct(cmd{}))
+}
+
+func TestFormat(t *testing.T) {
+	type cmd struct {
+		Format Format
+	}
+	newCmd := func() interface{} {
+		return &cmd{Format: NewFormat("table", "json", "yaml", "table")}
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Format: NewFormat("table", "json", "yaml", "table")}),
+		noErrorCase(cmd{Format: NewFormat("json", "json", "yaml", "table")}, "-format=json"),
+		errorMessageCase(`arg[0] "-format=xml": parsing value "xml" for flag "format": invalid format "xml": must be one of json, yaml, table`, "-format=xml"),
+		anyErrorCase("-format"),
+	}, newCmd)
+	f := NewFormat("json", "json", "yaml")
+	assert.Equal(t, "json", f.String())
+	assert.EqualError(t, f.Marshal("toml"), `invalid format "toml": must be one of json, yaml`)
+	require.NoError(t, f.Marshal("yaml"))
+	assert.Equal(t, "yaml", f.String())
+	assert.Equal(t, []string{"json", "yaml"}, f.Allowed())
+	_, err := NewParser(&cmd{})
+	assert.EqualError(t, err, `error adding flag in tagflag.cmd: "format" is a Format with no allowed values, create it with NewFormat`)
+}
+
+func TestVariadicTerminator(t *testing.T) {
+	type cmd struct {
+		X bool
+		StartPos
+		Exec []string `arity:"+"`
+		Dir  string   `arity:"?"`
+	}
+	cases := []parseCase{
+		noErrorCase(cmd{X: true, Exec: []string{"a", "b"}}, "a", "b", ";", "-x"),
+		noErrorCase(cmd{X: true, Exec: []string{"a", "-x"}, Dir: "d"}, "a", "-x", ";", "-x", "d"),
+		errorMessageCase(`arg[3] "-x": excess argument "-x": no positional arguments remain`, "a", ";", "d", "-x"),
+		noErrorCase(cmd{Exec: []string{"a", ";"}}, "--", "a", ";"),
+		errorMessageCase(`arg[0] ";": missing argument: "EXEC"`, ";", "a"),
+		errorMessageCase(`arg[3] "e": excess argument "e": no positional arguments remain`, "a", ";", "d", "e"),
+	}
+	RunCases(t, cases, newStruct(cmd{}), VariadicTerminator(""), ParseIntermixed(false))
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Exec: []string{"a", "+"}, Dir: "d"}, "a", "+", "end", "d"),
+	}, newStruct(cmd{}), VariadicTerminator("end"))
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Exec: []string{"a", ";", "b"}}, "a", ";", "b"),
+	}, newStruct(cmd{}))
+	// The terminator isn't counted as a positional argument, nor are the values skipped by it.
+	var c cmd
+	p, err := NewParser(&c, VariadicTerminator(""))
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"a", "b", ";"}))
+	assert.EqualValues(t, 2, p.NumPositional())
+	p, err = NewParser(&c, VariadicTerminator(""))
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"a", ";", "d"}))
+	assert.EqualValues(t, 2, p.NumPositional())
+}
+
+func TestMissingArgError(t *testing.T) {
+	var cmd struct {
+		StartPos
+		Src []string `arity:"+"`
+		Dst string
+	}
+	err := ParseErr(&cmd, nil)
+	var mae *MissingArgError
+	require.True(t, errors.As(err, &mae), "%v", err)
+	assert.Equal(t, MissingArgError{Name: "SRC", Min: 1, Max: -1}, *mae)
+	assert.EqualError(t, err, `missing argument: "SRC"`)
+	// It's still a user error, for the exit status.
+	var ue userError
+	assert.True(t, xerrors.As(err, &ue))
+
+	err = ParseErr(&cmd, []string{"a"})
+	require.True(t, errors.As(err, &mae), "%v", err)
+	assert.Equal(t, MissingArgError{Name: "DST", Min: 1, Max: 1}, *mae)
+}
+
+func TestFuncFlag(t *testing.T) {
+	var cmd struct {
+		Verbose bool
+	}
+	p, err := NewParser(&cmd, Program("prog"))
+	require.NoError(t, err)
+	var defines []string
+	require.NoError(t, p.Func("define", "defines a macro", func(s string) error {
+		if s == "" {
+			return errors.New("empty macro")
+		}
+		defines = append(defines, s)
+		return nil
+	}))
+	require.NoError(t, p.Parse([]string{"-define=a=1", "-verbose", "--define", "b"}))
+	assert.Equal(t, []string{"a=1", "b"}, defines)
+	assert.True(t, cmd.Verbose)
+	assert.True(t, p.IsSet("define"))
+	assert.EqualError(t, p.Parse([]string{"-define="}), `arg[0] "-define=": parsing value "" for flag "define": empty macro`)
+	assert.Error(t, p.Parse([]string{"-define"}))
+	assert.Error(t, p.Func("verbose", "", func(string) error { return nil }))
+
+	var buf bytes.Buffer
+	p.WriteUsage(&buf)
+	assert.Contains(t, buf.String(), "  -define    (string)   defines a macro\n")
+	buf.Reset()
+	require.NoError(t, p.WriteConfig(&buf, "flags"))
+	assert.Equal(t, "-verbose=true\n", buf.String())
+}
+
+func TestRightAlignOptionalPositionals(t *testing.T) {
+	type cmd struct {
+		Verbose bool
+		StartPos
+		User string `arity:"?"`
+		Host string
+		Port int    `arity:"?"`
+		Path string `arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Host: "h"}, "h"),
+		noErrorCase(cmd{Host: "h", Path: "p"}, "h", "p"),
+		noErrorCase(cmd{Host: "h", Port: 22, Path: "p"}, "h", "22", "p"),
+		noErrorCase(cmd{User: "u", Host: "h", Port: 22, Path: "p"}, "u", "h", "22", "p"),
+		noErrorCase(cmd{Verbose: true, Host: "h", Path: "p"}, "h", "-verbose", "p"),
+		errorMessageCase(`arg[1] "x": argument PORT: can't convert "x" to int: invalid syntax`, "h", "x", "p"),
+		errorMessageCase(`missing argument: "HOST"`),
+	}, newStruct(cmd{}), RightAlignOptionalPositionals())
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{User: "u", Host: "h"}, "u", "h"),
+		errorMessageCase(`missing argument: "HOST"`, "h"),
+	}, newStruct(cmd{}))
+	var c cmd
+	p, err := NewParser(&c, RightAlignOptionalPositionals())
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"h", "p"}))
+	assert.EqualValues(t, 2, p.NumPositional())
+}
+
+func TestMapOfSlicesFlag(t *testing.T) {
+	type cmd struct {
+		Header map[string][]string `mapsep:": "`
+		Env    map[string][]int
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Header: map[string][]string{
+			"X":            {"a", "b"},
+			"Content-Type": {"text/plain"},
+		}}, "-header=X: a", "-header=Content-Type: text/plain", "-header=X: b"),
+		noErrorCase(cmd{Env: map[string][]int{"a": {1, 2}, "b": {3}}}, "-env=a=1", "-env=b=3", "-env=a=2"),
+		errorMessageCase(`arg[0] "-header=X=a": parsing value "X=a" for flag "header": expected key: value`, "-header=X=a"),
+		anyErrorCase("-env=a=x"),
+	}, newStruct(cmd{}))
+}
+
+func TestNoDoubleDashSeparator(t *testing.T) {
+	type cmd struct {
+		Verbose bool
+		StartPos
+		Args []string `arity:"*"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Verbose: true, Args: []string{"a", "--", "b"}}, "a", "--", "-verbose", "b"),
+		noErrorCase(cmd{Args: []string{"--", "--"}}, "--", "--"),
+	}, newStruct(cmd{}), NoDoubleDashSeparator())
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Args: []string{"a", "-verbose", "b"}}, "a", "--", "-verbose", "b"),
+	}, newStruct(cmd{}))
+	var c cmd
+	p, err := NewParser(&c, NoDoubleDashSeparator())
+	require.NoError(t, err)
+	remaining, err := p.ParseKnownFlags([]string{"a", "--", "-verbose"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "--"}, remaining)
+	assert.True(t, c.Verbose)
+}
+
+func TestNilStructPointerAllocatedWhenSet(t *testing.T) {
+	type tls struct {
+		Cert string
+		Key  string `default:"key.pem"`
+	}
+	type server struct {
+		Addr string
+		TLS  *tls
+	}
+	type cmd struct {
+		Verbose bool
+		Server  *server
+		Debug   *struct {
+			Level int `count:"v"`
+		} `prefix:""`
+	}
+	var c cmd
+	p, err := NewParser(&c)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-verbose"}))
+	require.NoError(t, p.ApplyDefaults())
+	assert.Nil(t, c.Server)
+	assert.Nil(t, c.Debug)
+
+	c = cmd{}
+	p, err = NewParser(&c)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-server.addr=:443"}))
+	require.NotNil(t, c.Server)
+	assert.Equal(t, ":443", c.Server.Addr)
+	assert.Nil(t, c.Server.TLS)
+	assert.Nil(t, c.Debug)
+
+	c = cmd{}
+	p, err = NewParser(&c)
+	require.NoError(t, err)
+	require.NoError(t, p.Parse([]string{"-server.tls.cert=c.pem", "-v", "-v"}))
+	require.NoError(t, p.ApplyDefaults())
+	require.NotNil(t, c.Server)
+	require.NotNil(t, c.Server.TLS)
+	assert.Equal(t, tls{Cert: "c.pem", Key: "key.pem"}, *c.Server.TLS)
+	require.NotNil(t, c.Debug)
+	assert.Equal(t, 2, c.Debug.Level)
+
+	// Structs that are already allocated are used as they are.
+	existing := &server{Addr: ":80"}
+	c = cmd{Server: existing}
+	require.NoError(t, ParseErr(&c, []string{"-server.addr=:81"}))
+	assert.True(t, existing == c.Server)
+	assert.Equal(t, ":81", existing.Addr)
+}
+
+func TestLengthBounds(t *testing.T) {
+	type cmd struct {
+		User  string  `minlen:"3" maxlen:"5"`
+		Token *string `maxlen:"4"`
+		StartPos
+		Name string `minlen:"1" arity:"?"`
+	}
+	s := func(s string) *string { return &s }
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{User: "abc"}, "-user=abc"),
+		noErrorCase(cmd{User: "abcde", Token: s("ab"), Name: "x"}, "-user=abcde", "-token=ab", "x"),
+		noErrorCase(cmd{User: "äöü"}, "-user=äöü"),
+		errorMessageCase(`arg[0] "-user=ab": parsing value "ab" for flag "user": value has length 2, less than minimum 3`, "-user=ab"),
+		errorMessageCase(`arg[0] "-user=abcdef": parsing value "abcdef" for flag "user": value has length 6, greater than maximum 5`, "-user=abcdef"),
+		errorMessageCase(`arg[0] "-token=abcde": parsing value "abcde" for flag "token": value has length 5, greater than maximum 4`, "-token=abcde"),
+		errorMessageCase(`arg[0] "": value has length 0, less than minimum 1`, ""),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Port int `maxlen:"5"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+	var badTag struct {
+		User string `minlen:"three"`
+	}
+	assert.Error(t, ParseErr(&badTag, nil))
+}
+
+func TestRangesTag(t *testing.T) {
+	type cmd struct {
+		Pages []int   `ranges:"true"`
+		Bytes []uint8 `ranges:"true"`
+		Plain []int
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{Pages: []int{1, 2, 3, 5, 7, 8}}, "-pages=1-3,5,7-8"),
+		noErrorCase(cmd{Pages: []int{4, 4, 1}}, "-pages=4-4", "-pages=4,1"),
+		noErrorCase(cmd{Bytes: []byte{254, 255}, Plain: []int{1}}, "-bytes=254-255", "-plain=1"),
+		errorMessageCase(`arg[0] "-pages=3-1": parsing value "3-1" for flag "pages": range "3-1" is reversed`, "-pages=3-1"),
+		errorMessageCase(`arg[0] "-pages=1-3,2-4": parsing value "1-3,2-4" for flag "pages": range "2-4" overlaps an earlier range at 2`, "-pages=1-3,2-4"),
+		errorMessageCase(`arg[0] "-pages=1,,2": parsing value "1,,2" for flag "pages": parsing range "": strconv.ParseUint: parsing "": invalid syntax`, "-pages=1,,2"),
+		errorMessageCase(`arg[0] "-bytes=255-256": parsing value "255-256" for flag "bytes": 256 overflows uint8`, "-bytes=255-256"),
+		errorMessageCase(`arg[0] "-pages=-1-3": parsing value "-1-3" for flag "pages": range "-1-3" can't start with -`, "-pages=-1-3"),
+		errorMessageCase(`arg[0] "-pages=0-18446744073709551615": parsing value "0-18446744073709551615" for flag "pages": ranges "0-18446744073709551615" expand to more than 65536 integers`, "-pages=0-18446744073709551615"),
+		errorMessageCase(`arg[0] "-pages=1-100000000": parsing value "1-100000000" for flag "pages": ranges "1-100000000" expand to more than 65536 integers`, "-pages=1-100000000"),
+		errorMessageCase(`arg[0] "-pages=1-65536,65537": parsing value "1-65536,65537" for flag "pages": ranges "1-65536,65537" expand to more than 65536 integers`, "-pages=1-65536,65537"),
+		anyErrorCase("-pages=a-b"),
+		anyErrorCase("-pages=1-"),
+		anyErrorCase("-plain=1-3"),
+	}, newStruct(cmd{}))
+	var bad struct {
+		Pages []string `ranges:"true"`
+	}
+	assert.Error(t, ParseErr(&bad, nil))
+}
+
+func TestUsageOnNoArgs(t *testing.T) {
+	type cmd struct {
+		Verbose bool
+		StartPos
+		Src string
+		Dst string
+	}
+	RunCases(t, []parseCase{
+		errorIsCase(ErrDefaultHelp),
+		errorMessageCase(`missing argument: "DST"`, "a"),
+		errorMessageCase(`missing argument: "SRC"`, "-verbose"),
+		noErrorCase(cmd{Src: "a", Dst: "b"}, "a", "b"),
+	}, newStruct(cmd{}), UsageOnNoArgs())
+	RunCases(t, []parseCase{
+		errorMessageCase(`missing argument: "SRC"`),
+	}, newStruct(cmd{}))
+	type optional struct {
+		StartPos
+		Src string `arity:"?"`
+	}
+	RunCases(t, []parseCase{
+		noErrorCase(optional{}),
+	}, newStruct(optional{}), UsageOnNoArgs())
+
+	var usage string
+	var c cmd
+	p, err := NewParser(&c, UsageOnNoArgs(), SoftHelp(func(u string) { usage = u }))
+	require.NoError(t, err)
+	require.NoError(t, p.Parse(nil))
+	assert.True(t, p.HelpShown())
+	assert.Contains(t, usage, "SRC")
+}
+
+func TestTimeLocation(t *testing.T) {
+	type cmd struct {
+		TZ *time.Location `name:"tz"`
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	RunCases(t, []parseCase{
+		noErrorCase(cmd{}),
+		noErrorCase(cmd{TZ: ny}, "-tz=America/New_York"),
+		noErrorCase(cmd{TZ: time.UTC}, "-tz=UTC"),
+		noErrorCase(cmd{TZ: time.Local}, "-tz=Local"),
+		errorMessageCase(`arg[0] "-tz=Mars/Olympus_Mons": parsing value "Mars/Olympus_Mons" for flag "tz": unknown time zone Mars/Olympus_Mons`, "-tz=Mars/Olympus_Mons"),
+		errorMessageCase(`arg[0] "-tz=": parsing value "" for flag "tz": empty time zone name`, "-tz="),
+		anyErrorCase("-tz"),
+	}, newStruct(cmd{}))
+	c := cmd{TZ: ny}
+	assert.Contains(t, usageString(t, &c), "(Default: America/New_York)")
+}
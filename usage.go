@@ -3,10 +3,17 @@ package tagflag
 import (
 	"fmt"
 	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"time"
+	"unicode/utf8"
 
 	"github.com/anacrolix/missinggo/v2"
 	"github.com/anacrolix/missinggo/v2/slices"
+	"github.com/huandu/xstrings"
 )
 
 func (p *Parser) printPosArgUsage(w io.Writer) {
@@ -37,55 +44,292 @@ func (p *Parser) printPosArgUsage(w io.Writer) {
 	}
 }
 
-func (p *Parser) printUsage(w io.Writer) {
-	fmt.Fprintf(w, "Usage:\n  %s", p.program)
+// Writes the required flags for the synopsis, in declaration order. Optional flags are represented
+// by the OptionsPlaceholder.
+func (p *Parser) printRequiredFlagUsage(w io.Writer) {
+	for _, name := range p.flagOrder {
+		f := p.flags[name]
+		if !f.isRequired() {
+			continue
+		}
+		if isBoolType(f.value.Type()) {
+			fmt.Fprintf(w, " %s%s", flagPrefix, f.name)
+		} else {
+			fmt.Fprintf(w, " %s%s=%s", flagPrefix, f.name, strings.ToUpper(xstrings.ToSnakeCase(f.name)))
+		}
+	}
+}
+
+// The literal text used in usage output. Empty fields use the corresponding DefaultUsageLabels.
+type UsageLabelSet struct {
+	Usage     string
+	Arguments string
+	Options   string
+	// Stands in for all the options in the synopsis line.
+	OptionsPlaceholder string
+	// Precedes the default value of an option.
+	Default string
+	// Precedes the permitted values of an option.
+	Choices string
+	// Marks a required option.
+	Required string
+	// Heads the settings that are only read from the environment.
+	Environment string
+	// Precedes the environment variable an option is read from, in WriteFlagHelp.
+	Env string
+}
+
+var DefaultUsageLabels = UsageLabelSet{
+	Usage:              "Usage:",
+	Arguments:          "Arguments:",
+	Options:            "Options:",
+	OptionsPlaceholder: "[OPTIONS...]",
+	Default:            "Default:",
+	Choices:            "Choices:",
+	Required:           "(required)",
+	Environment:        "Environment:",
+	Env:                "Env:",
+}
+
+// Returns the labels with any empty fields filled from DefaultUsageLabels.
+func (me UsageLabelSet) withDefaults() UsageLabelSet {
+	fill := func(s *string, def string) {
+		if *s == "" {
+			*s = def
+		}
+	}
+	fill(&me.Usage, DefaultUsageLabels.Usage)
+	fill(&me.Arguments, DefaultUsageLabels.Arguments)
+	fill(&me.Options, DefaultUsageLabels.Options)
+	fill(&me.OptionsPlaceholder, DefaultUsageLabels.OptionsPlaceholder)
+	fill(&me.Default, DefaultUsageLabels.Default)
+	fill(&me.Choices, DefaultUsageLabels.Choices)
+	fill(&me.Required, DefaultUsageLabels.Required)
+	fill(&me.Environment, DefaultUsageLabels.Environment)
+	fill(&me.Env, DefaultUsageLabels.Env)
+	return me
+}
+
+// Writes the usage message, as shown for -help. It includes a synopsis, the description, and
+// details of the positional arguments and options.
+func (p *Parser) WriteUsage(w io.Writer) {
+	style := p.usageStyle(w)
+	fmt.Fprintf(w, "%s\n  %s", style.header(style.Usage), p.program)
+	p.printRequiredFlagUsage(w)
 	if p.hasOptions() {
-		fmt.Fprintf(w, " [OPTIONS...]")
+		fmt.Fprintf(w, " %s", style.OptionsPlaceholder)
 	}
 	p.printPosArgUsage(w)
 	fmt.Fprintf(w, "\n")
 	if p.description != "" {
-		fmt.Fprintf(w, "\n%s\n", missinggo.Unchomp(p.description))
+		fmt.Fprintf(w, "\n%s\n", missinggo.Unchomp(wrapText(p.description, p.usageWidth())))
 	}
-	if awd := p.posWithHelp(); len(awd) != 0 {
-		fmt.Fprintf(w, "Arguments:\n")
+	if len(p.posArgs) != 0 {
+		fmt.Fprintf(w, "%s\n", style.header(style.Arguments))
 		tw := newUsageTabwriter(w)
-		for _, a := range awd {
-			fmt.Fprintf(tw, "  %s\t(%s)\t%s\n", a.name, a.value.Type(), a.help)
+		for _, a := range p.posArgs {
+			fmt.Fprintf(tw, "  %s\t(%s)\t%s\n", style.name(a.positionalUsageName()), a.typeName(), a.usageHelp(style.UsageLabelSet))
 		}
 		tw.Flush()
 	}
-	var opts []arg
-	for _, v := range p.flags {
-		opts = append(opts, v)
+	writeOptionUsage(w, style.Options, p.groupFlags(""), style)
+	for _, g := range p.groups {
+		writeOptionUsage(w, g+":", p.groupFlags(g), style)
+	}
+	if len(p.envOnly) != 0 {
+		fmt.Fprintf(w, "%s\n", style.header(style.Environment))
+		tw := newUsageTabwriter(w)
+		for _, a := range p.envOnly {
+			fmt.Fprintf(tw, "  %s\t(%s)\t%s\n", style.name(p.envVar(a)), a.typeName(), a.usageHelp(style.UsageLabelSet))
+		}
+		tw.Flush()
+	}
+}
+
+// The width that usage is wrapped to if it isn't given by UsageWidth or $COLUMNS.
+const defaultUsageWidth = 80
+
+// Returns the width to wrap usage to, from UsageWidth, or the COLUMNS environment variable that
+// shells set to the width of the terminal.
+func (p *Parser) usageWidth() int {
+	if p.width > 0 {
+		return p.width
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultUsageWidth
+}
+
+// Breaks lines of s longer than width at spaces. Existing line breaks, and so blank lines between
+// paragraphs, are kept, and continuation lines have the same indent as the line they continue.
+// Words longer than the width aren't broken.
+func wrapText(s string, width int) string {
+	lines := strings.Split(s, "\n")
+	var out []string
+	for _, line := range lines {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		cur := ""
+		for _, word := range strings.Fields(line) {
+			switch {
+			case cur == "":
+				cur = indent + word
+			case utf8.RuneCountInString(cur)+1+utf8.RuneCountInString(word) > width:
+				out = append(out, cur)
+				cur = indent + word
+			default:
+				cur += " " + word
+			}
+		}
+		out = append(out, cur)
+	}
+	return strings.Join(out, "\n")
+}
+
+// Returns the flags in the usage group, in declaration order, or ordered by name for the flags
+// without a group. Experimental flags are omitted unless they're enabled.
+func (p *Parser) groupFlags(group string) (ret []arg) {
+	for _, name := range p.flagOrder {
+		v := p.flags[name]
+		if v.isExperimental() && !p.experimentalEnabled() {
+			continue
+		}
+		if v.group == group {
+			ret = append(ret, v)
+		}
+	}
+	if group == "" {
+		// Ungrouped flags have always been listed by name.
+		slices.Sort(ret, func(left, right arg) bool {
+			return left.name < right.name
+		})
 	}
-	slices.Sort(opts, func(left, right arg) bool {
-		return left.name < right.name
-	})
-	writeOptionUsage(w, opts)
+	return
 }
 
 func newUsageTabwriter(w io.Writer) *tabwriter.Writer {
 	return tabwriter.NewWriter(w, 8, 2, 3, ' ', 0)
 }
 
-func writeOptionUsage(w io.Writer, flags []arg) {
+// Flag names in the options table longer than this are put on their own line, rather than widening
+// the name column for every flag.
+const maxOptionNameWidth = 32
+
+// Writes the options table. It's laid out like newUsageTabwriter, except that the name column is
+// capped at maxOptionNameWidth.
+func writeOptionUsage(w io.Writer, header string, flags []arg, style usageStyle) {
 	if len(flags) == 0 {
 		return
 	}
-	fmt.Fprintf(w, "Options:\n")
-	tw := newUsageTabwriter(w)
+	fmt.Fprintf(w, "%s\n", style.header(header))
+	const (
+		minWidth = 8
+		padding  = 3
+	)
+	nameWidth, typeWidth := minWidth, minWidth
 	for _, f := range flags {
-		fmt.Fprint(tw, "  ")
-		fmt.Fprintf(tw, "%s%s", flagPrefix, f.name)
-		help := f.help
-		if !f.hasZeroValue() {
-			if help != "" {
-				help += " "
-			}
-			help += fmt.Sprintf("(Default: %v)", f.value)
+		if n := utf8.RuneCountInString(f.usageName()); n <= maxOptionNameWidth {
+			nameWidth = maxInt(nameWidth, 2+n+padding)
 		}
-		fmt.Fprintf(tw, "\t(%s)\t%s\n", f.value.Type(), help)
+		typeWidth = maxInt(typeWidth, utf8.RuneCountInString(f.typeName())+2+padding)
+	}
+	for _, f := range flags {
+		name := f.usageName()
+		nameLen := 2 + utf8.RuneCountInString(name)
+		fmt.Fprintf(w, "  %s", style.name(name))
+		if nameLen-2 > maxOptionNameWidth {
+			fmt.Fprint(w, "\n")
+			nameLen = 0
+		}
+		typ := fmt.Sprintf("(%s)", f.typeName())
+		fmt.Fprintf(w, "%s%s%s%s\n",
+			strings.Repeat(" ", nameWidth-nameLen),
+			typ,
+			strings.Repeat(" ", typeWidth-utf8.RuneCountInString(typ)),
+			f.usageHelp(style.UsageLabelSet))
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Returns the flag's name, preceded by its short name if it has one.
+func (me arg) usageName() string {
+	if me.short != "" {
+		return fmt.Sprintf("%s%s, %s%s", flagPrefix, me.short, flagPrefix, me.name)
+	}
+	return flagPrefix + me.name
+}
+
+// Example values shown with positional arguments of types whose format isn't evident from the type
+// name.
+var exampleHints = map[reflect.Type]string{
+	reflect.TypeOf(Bytes(0)):         "100MB",
+	reflect.TypeOf(time.Duration(0)): "30s",
+}
+
+// Returns the positional argument's name, followed by an example value if its type has one.
+func (me arg) positionalUsageName() string {
+	t := me.value.Type()
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if hint, ok := exampleHints[t]; ok {
+		return fmt.Sprintf("%s (e.g. %s)", me.name, hint)
+	}
+	return me.name
+}
+
+// Returns the help text for the arg, followed by whether it's required, any related flags, and its
+// default value if it has one.
+func (me arg) usageHelp(labels UsageLabelSet) string {
+	help := me.help
+	if me.isRequired() {
+		if help != "" {
+			help += " "
+		}
+		help += labels.Required
+	}
+	if names := me.seeAlso(); len(names) != 0 {
+		if help != "" {
+			help += " "
+		}
+		help += fmt.Sprintf("(see also %s%s)", flagPrefix, strings.Join(names, ", "+flagPrefix))
+	}
+	if !me.hasZeroValue() && me.derivedFrom == "" {
+		if help != "" {
+			help += " "
+		}
+		help += fmt.Sprintf("(%s %s)", labels.Default, me.renderValue())
+	}
+	return help
+}
+
+// Writes detailed help for the flag with the given name, including its type, default value, and
+// any restriction on its values.
+func (p *Parser) WriteFlagHelp(w io.Writer, name string) error {
+	f, ok := p.flags[name]
+	if !ok {
+		return p.unknownFlagError(name)
+	}
+	style := p.usageStyle(w)
+	labels := style.UsageLabelSet
+	fmt.Fprintf(w, "%s (%s)\n", style.name(f.usageName()), f.typeName())
+	if f.help != "" {
+		fmt.Fprintf(w, "  %s\n", f.help)
+	}
+	if !f.hasZeroValue() {
+		fmt.Fprintf(w, "  %s %s\n", labels.Default, f.renderValue())
+	}
+	if env, ok := p.flagEnvVar(f); ok {
+		fmt.Fprintf(w, "  %s %s\n", labels.Env, env)
+	}
+	if choices := f.allowedValues(); len(choices) != 0 {
+		fmt.Fprintf(w, "  %s %s\n", labels.Choices, strings.Join(choices, ", "))
 	}
-	tw.Flush()
+	return nil
 }
@@ -0,0 +1,90 @@
+package tagflag
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Describes a flag or positional argument in the output of WriteUsageJSON.
+type ArgUsage struct {
+	Name     string   `json:"name"`
+	Short    string   `json:"short,omitempty"`
+	Type     string   `json:"type"`
+	Help     string   `json:"help,omitempty"`
+	Default  string   `json:"default,omitempty"`
+	Required bool     `json:"required,omitempty"`
+	Choices  []string `json:"choices,omitempty"`
+	Group    string   `json:"group,omitempty"`
+	// The environment variable the flag is read from, per EnvPrefix.
+	Env string `json:"env,omitempty"`
+}
+
+// Describes a command in the output of WriteUsageJSON.
+type CommandUsage struct {
+	Program     string     `json:"program"`
+	Description string     `json:"description,omitempty"`
+	Flags       []ArgUsage `json:"flags"`
+	Positional  []ArgUsage `json:"positional"`
+}
+
+// Writes a JSON description of the command, with the information in the usage message, for use by
+// external tools such as documentation generators.
+func (p *Parser) WriteUsageJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(p.commandUsage())
+}
+
+func (p *Parser) commandUsage() CommandUsage {
+	cu := CommandUsage{
+		Program:     p.program,
+		Description: p.description,
+		Flags:       []ArgUsage{},
+		Positional:  []ArgUsage{},
+	}
+	for _, g := range append([]string{""}, p.groups...) {
+		for _, f := range p.groupFlags(g) {
+			au := f.argUsage()
+			au.Env, _ = p.flagEnvVar(f)
+			cu.Flags = append(cu.Flags, au)
+		}
+	}
+	for _, a := range p.posArgs {
+		au := a.argUsage()
+		au.Required = a.arity.min != 0
+		cu.Positional = append(cu.Positional, au)
+	}
+	return cu
+}
+
+// Describes a command and its subcommands, as returned by Parser.CommandTree.
+type CommandNode struct {
+	CommandUsage
+	Subcommands []CommandNode `json:"subcommands,omitempty"`
+}
+
+// Returns a description of the command, and recursively the commands of the Parsers created with
+// it as their Parent, such as for generating documentation.
+func (p *Parser) CommandTree() CommandNode {
+	n := CommandNode{CommandUsage: p.commandUsage()}
+	for _, c := range p.children {
+		n.Subcommands = append(n.Subcommands, c.CommandTree())
+	}
+	return n
+}
+
+func (me arg) argUsage() ArgUsage {
+	au := ArgUsage{
+		Name:     me.name,
+		Short:    me.short,
+		Type:     me.typeName(),
+		Help:     me.help,
+		Required: me.isRequired(),
+		Choices:  me.allowedValues(),
+		Group:    me.group,
+	}
+	if !me.hasZeroValue() && me.derivedFrom == "" {
+		au.Default = me.renderValue()
+	}
+	return au
+}
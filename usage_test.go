@@ -0,0 +1,551 @@
+package tagflag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func usageString(t *testing.T, cmd interface{}, opts ...parseOpt) string {
+	p, err := NewParser(cmd, opts...)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	p.WriteUsage(&buf)
+	return buf.String()
+}
+
+func TestUsageLabels(t *testing.T) {
+	cmd := struct {
+		Level int
+		StartPos
+		File string `help:"input file"`
+	}{Level: 2}
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...] <FILE>
+Arguments:
+  FILE   (string)   input file
+Options:
+  -level   (int)   (Default: 2)
+`, usageString(t, &cmd, Program("prog")))
+	assert.Equal(t, `Uso:
+  prog [OPCIONES...] <FILE>
+Argumentos:
+  FILE   (string)   input file
+Opciones:
+  -level   (int)   (Predeterminado: 2)
+`, usageString(t, &cmd, Program("prog"), UsageLabels(UsageLabelSet{
+		Usage:              "Uso:",
+		Arguments:          "Argumentos:",
+		Options:            "Opciones:",
+		OptionsPlaceholder: "[OPCIONES...]",
+		Default:            "Predeterminado:",
+	})))
+	assert.Contains(t, usageString(t, &cmd, UsageLabels(UsageLabelSet{Usage: "Uso:"})), "Options:\n")
+}
+
+func TestWriteUsage(t *testing.T) {
+	cmd := struct {
+		Verbose bool   `name:"v" help:"log more"`
+		Workers int    `help:"number of workers"`
+		Addr    string `help:"listen address"`
+		StartPos
+		Input  string   `help:"input file"`
+		Output string   `arity:"?"`
+		Extra  []string `arity:"*" help:"extra files"`
+	}{Workers: 4}
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...] <INPUT> [OUTPUT] [EXTRA...]
+
+Copies files.
+
+Arguments:
+  INPUT    (string)     input file
+  OUTPUT   (string)     
+  EXTRA    ([]string)   extra files
+Options:
+  -addr      (string)   listen address
+  -v         (bool)     log more
+  -workers   (int)      number of workers (Default: 4)
+`, usageString(t, &cmd, Program("prog"), Description("Copies files.")))
+}
+
+func TestWriteFlagHelp(t *testing.T) {
+	cmd := struct {
+		Level   string `choices:"debug,info,warn" help:"minimum log level"`
+		Workers int
+	}{Level: "info"}
+	p, err := NewParser(&cmd)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteFlagHelp(&buf, "level"))
+	assert.Equal(t, `-level (string)
+  minimum log level
+  Default: info
+  Choices: debug, info, warn
+`, buf.String())
+	assert.EqualError(t, p.WriteFlagHelp(&buf, "wrokers"), `unknown flag: "wrokers" (did you mean "workers"?)`)
+	assert.EqualError(t, p.WriteFlagHelp(&buf, "zzz"), `unknown flag: "zzz"`)
+}
+
+func TestWriteFlagHelpEnv(t *testing.T) {
+	var cmd struct {
+		ListenAddr string `help:"listen address"`
+		Debug      bool   `noenv:"true"`
+	}
+	p, err := NewParser(&cmd, EnvPrefix("MYAPP"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteFlagHelp(&buf, "listenAddr"))
+	assert.Equal(t, `-listenAddr (string)
+  listen address
+  Env: MYAPP_LISTEN_ADDR
+`, buf.String())
+	buf.Reset()
+	require.NoError(t, p.WriteFlagHelp(&buf, "debug"))
+	assert.Equal(t, "-debug (bool)\n", buf.String())
+	buf.Reset()
+	require.NoError(t, p.WriteUsageJSON(&buf))
+	var cu CommandUsage
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &cu))
+	assert.EqualValues(t, []ArgUsage{
+		{Name: "debug", Type: "bool"},
+		{Name: "listenAddr", Type: "string", Help: "listen address", Env: "MYAPP_LISTEN_ADDR"},
+	}, cu.Flags)
+}
+
+func TestUsageColor(t *testing.T) {
+	var cmd struct {
+		Verbose bool `name:"v"`
+		Workers int
+	}
+	plain := usageString(t, &cmd, Program("prog"))
+	assert.NotContains(t, plain, "\x1b[")
+	assert.Equal(t, plain, usageString(t, &cmd, Program("prog"), Color(false)))
+	colored := usageString(t, &cmd, Program("prog"), Color(true))
+	assert.Equal(t, "\x1b[1mUsage:\x1b[0m\n  prog [OPTIONS...]\n"+
+		"\x1b[1mOptions:\x1b[0m\n"+
+		"  \x1b[36m-v\x1b[0m         (bool)   \n"+
+		"  \x1b[36m-workers\x1b[0m   (int)    \n", colored)
+}
+
+func TestNoColorEnv(t *testing.T) {
+	f, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer f.Close()
+	// The null device is a character device, like a terminal.
+	defer unsetenv("NO_COLOR")()
+	assert.True(t, isColorTerminal(f))
+	// Per the spec, NO_COLOR must be non-empty to disable color.
+	defer setenv("NO_COLOR", "")()
+	assert.True(t, isColorTerminal(f))
+	defer setenv("NO_COLOR", "1")()
+	assert.False(t, isColorTerminal(f))
+}
+
+func TestUsageGroups(t *testing.T) {
+	type TLS struct {
+		Key  string
+		Cert string
+	}
+	var cmd struct {
+		Verbose bool `name:"v"`
+		Server  struct {
+			Port int
+			Addr string
+		}
+		TLS
+		Misc struct {
+			Debug bool
+		} `group:""`
+	}
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...]
+Options:
+  -misc.debug   (bool)   
+  -v            (bool)   
+Server:
+  -server.port   (int)      
+  -server.addr   (string)   
+TLS:
+  -key    (string)   
+  -cert   (string)   
+`, usageString(t, &cmd, Program("prog")))
+}
+
+func TestDescriptionTag(t *testing.T) {
+	var cmd struct {
+		_    struct{} `description:"Frobs the widgets."`
+		Name string
+	}
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...]
+
+Frobs the widgets.
+
+Options:
+  -name   (string)   
+`, usageString(t, &cmd, Program("prog")))
+	assert.Contains(t, usageString(t, &cmd, Description("Overridden.")), "\nOverridden.\n")
+	assert.NotContains(t, usageString(t, &cmd, Description("Overridden.")), "widgets")
+}
+
+func TestUsagePositionalExampleHints(t *testing.T) {
+	cmd := struct {
+		StartPos
+		Size    Bytes
+		Timeout time.Duration
+		Name    string
+	}{}
+	assert.Equal(t, `Usage:
+  prog <SIZE> <TIMEOUT> <NAME>
+Arguments:
+  SIZE (e.g. 100MB)    (tagflag.Bytes)   
+  TIMEOUT (e.g. 30s)   (time.Duration)   
+  NAME                 (string)          
+`, usageString(t, &cmd, Program("prog")))
+}
+
+func TestUsageSeeAlso(t *testing.T) {
+	cmd := struct {
+		TLSCert  string `help:"certificate file" seealso:"tlsKey"`
+		TLSKey   string `seealso:"tlsCert,insecure"`
+		Insecure bool
+	}{}
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...]
+Options:
+  -insecure   (bool)     
+  -tlsCert    (string)   certificate file (see also -tlsKey)
+  -tlsKey     (string)   (see also -tlsCert, -insecure)
+`, usageString(t, &cmd, Program("prog")))
+	var bad struct {
+		TLSCert string `seealso:"tls-key"`
+	}
+	_, err := NewParser(&bad)
+	assert.EqualError(t, err, `flag "tlsCert" has seealso tag referring to unknown flag "tls-key"`)
+}
+
+func TestUsageRequiredFlags(t *testing.T) {
+	cmd := struct {
+		APIKey  string `required:"true" help:"service key"`
+		Workers int
+		Force   bool `required:"true"`
+		StartPos
+		File string
+	}{}
+	assert.Equal(t, `Usage:
+  prog -apiKey=API_KEY -force [OPTIONS...] <FILE>
+Arguments:
+  FILE   (string)   
+Options:
+  -apiKey    (string)   service key (required)
+  -force     (bool)     (required)
+  -workers   (int)      
+`, usageString(t, &cmd, Program("prog")))
+}
+
+func TestUsageHelpText(t *testing.T) {
+	cmd := struct {
+		Addr    string `help:"listen address"`
+		Workers int    `help:"number of workers"`
+		StartPos
+		File string
+	}{}
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...] <FILE>
+Arguments:
+  FILE   (string)   fichier d'entrée
+Options:
+  -addr      (string)   adresse d'écoute
+  -workers   (int)      number of workers
+`, usageString(t, &cmd, Program("prog"), HelpText(map[string]string{
+		"addr": "adresse d'écoute",
+		"FILE": "fichier d'entrée",
+	})))
+	_, err := NewParser(&cmd, HelpText(map[string]string{"port": "port"}))
+	assert.EqualError(t, err, `help text given for unknown flag or argument "port"`)
+}
+
+func TestWriteUsageJSON(t *testing.T) {
+	cmd := struct {
+		_       struct{} `description:"Copies files."`
+		Workers int      `help:"number of workers"`
+		Mode    string   `choices:"fast,slow" required:"true"`
+		Net     struct {
+			Addr string `help:"listen address"`
+		} `group:"Network"`
+		StartPos
+		Input  string
+		Output string `arity:"?"`
+	}{Workers: 4}
+	p, err := NewParser(&cmd, Program("prog"), AutoShort())
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteUsageJSON(&buf))
+	var cu CommandUsage
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &cu))
+	assert.EqualValues(t, CommandUsage{
+		Program:     "prog",
+		Description: "Copies files.",
+		Flags: []ArgUsage{
+			{Name: "mode", Short: "m", Type: "string", Required: true, Choices: []string{"fast", "slow"}},
+			{Name: "workers", Short: "w", Type: "int", Help: "number of workers", Default: "4"},
+			{Name: "net.addr", Short: "n", Type: "string", Help: "listen address", Group: "Network"},
+		},
+		Positional: []ArgUsage{
+			{Name: "INPUT", Type: "string", Required: true},
+			{Name: "OUTPUT", Type: "string"},
+		},
+	}, cu)
+	assert.Contains(t, buf.String(), `"choices": [`)
+}
+
+func TestUsageSynopsis(t *testing.T) {
+	synopsis := func(cmd interface{}) string {
+		return strings.SplitN(usageString(t, cmd, Program("prog")), "\n", 3)[1]
+	}
+	assert.Equal(t, "  prog -user=USER -token=TOKEN", synopsis(&struct {
+		User  string `required:"true"`
+		Token string `required:"true"`
+	}{}))
+	assert.Equal(t, "  prog -user=USER [OPTIONS...] <HOST> [PORT]", synopsis(&struct {
+		Verbose bool
+		User    string `required:"true"`
+		Timeout time.Duration
+		StartPos
+		Host string
+		Port int `arity:"?"`
+	}{}))
+	assert.Equal(t, "  prog [FILES...]", synopsis(&struct {
+		StartPos
+		Files []string `arity:"*"`
+	}{}))
+}
+
+func TestEnvOnly(t *testing.T) {
+	type cmd struct {
+		Addr  string
+		Token string `envonly:"true" help:"API token"`
+	}
+	defer setenv("MYAPP_TOKEN", "s3cret")()
+	var c cmd
+	require.NoError(t, ParseErr(&c, []string{"-addr=:80"}, EnvPrefix("MYAPP")))
+	assert.EqualValues(t, "s3cret", c.Token)
+	RunCases(t, []parseCase{
+		errorIsCase(userError{`unknown flag: "token"`}, "-token=x"),
+	}, newStruct(cmd{}), EnvPrefix("MYAPP"))
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...]
+Options:
+  -addr   (string)   
+Environment:
+  MYAPP_TOKEN   (string)   API token
+`, usageString(t, &cmd{}, Program("prog"), EnvPrefix("MYAPP")))
+	// Without a prefix, a bare name such as PATH could be read by accident.
+	defer setenv("TOKEN", "t")()
+	_, err := NewParser(&c)
+	assert.EqualError(t, err, `envonly setting "token" needs EnvPrefix or an env tag`)
+	var named struct {
+		Token string `envonly:"true" env:"API_TOKEN"`
+	}
+	defer setenv("API_TOKEN", "named")()
+	require.NoError(t, ParseErr(&named, nil))
+	assert.EqualValues(t, "named", named.Token)
+	assert.Contains(t, usageString(t, &named), "API_TOKEN")
+	var secret struct {
+		Pin int `envonly:"true" env:"API_PIN" secret:"true"`
+	}
+	defer setenv("API_PIN", "hunter2")()
+	err = ParseErr(&secret, nil)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "hunter2")
+	var flag struct {
+		Token string `env:"API_TOKEN"`
+	}
+	_, err = NewParser(&flag, EnvPrefix("MYAPP"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `flag "token" has an env tag, which is only for envonly settings`)
+}
+
+func TestUsageLongFlagName(t *testing.T) {
+	cmd := struct {
+		Addr                                   string `help:"listen address"`
+		MaximumNumberOfSimultaneousConnections int    `help:"connection limit"`
+		V                                      bool   `help:"verbose"`
+	}{}
+	assert.Equal(t, `Usage:
+  prog [OPTIONS...]
+Options:
+  -addr   (string)   listen address
+  -maximumNumberOfSimultaneousConnections
+          (int)      connection limit
+  -v      (bool)     verbose
+`, usageString(t, &cmd, Program("prog")))
+}
+
+func TestCommandTree(t *testing.T) {
+	var root struct {
+		Verbose bool
+		Subcommand
+		Command string
+		ExcessArgs
+	}
+	var serve struct {
+		_    struct{} `description:"Runs the server."`
+		Addr string   `help:"listen address"`
+	}
+	var status struct {
+		StartPos
+		Service string
+	}
+	rp, err := NewParser(&root, Program("prog"))
+	require.NoError(t, err)
+	_, err = NewParser(&serve, Program("serve"), Parent(rp))
+	require.NoError(t, err)
+	_, err = NewParser(&status, Program("status"), Parent(rp))
+	require.NoError(t, err)
+	assert.EqualValues(t, CommandNode{
+		CommandUsage: CommandUsage{
+			Program:    "prog",
+			Flags:      []ArgUsage{{Name: "verbose", Type: "bool"}},
+			Positional: []ArgUsage{{Name: "COMMAND", Type: "string", Required: true}},
+		},
+		Subcommands: []CommandNode{
+			{CommandUsage: CommandUsage{
+				Program:     "serve",
+				Description: "Runs the server.",
+				Flags:       []ArgUsage{{Name: "addr", Type: "string", Help: "listen address"}},
+				Positional:  []ArgUsage{},
+			}},
+			{CommandUsage: CommandUsage{
+				Program:    "status",
+				Flags:      []ArgUsage{},
+				Positional: []ArgUsage{{Name: "SERVICE", Type: "string", Required: true}},
+			}},
+		},
+	}, rp.CommandTree())
+}
+
+func TestFormatUsage(t *testing.T) {
+	cmd := struct {
+		Output Format `help:"output format"`
+	}{Output: NewFormat("table", "json", "table")}
+	p, err := NewParser(&cmd, Program("prog"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, p.WriteFlagHelp(&buf, "output"))
+	assert.Equal(t, `-output (tagflag.Format)
+  output format
+  Default: table
+  Choices: json, table
+`, buf.String())
+	assert.Contains(t, usageString(t, &cmd), "output format (Default: table)")
+	pos := struct {
+		StartPos
+		Output Format
+	}{Output: NewFormat("table", "json", "table")}
+	p, err = NewParser(&pos, Program("prog"))
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, p.WriteBashCompletion(&buf))
+	assert.Contains(t, buf.String(), `0) COMPREPLY=($(compgen -W "json table" -- "$cur")) ;;`)
+}
+
+func TestShortErrorUsage(t *testing.T) {
+	var cmd struct {
+		Verbose bool `help:"print more"`
+	}
+	p, err := NewParser(&cmd, Program("prog"), ShortErrorUsage())
+	require.NoError(t, err)
+	err = p.Parse([]string{"-bad"})
+	require.Error(t, err)
+	var buf bytes.Buffer
+	p.writeParseError(&buf, err)
+	assert.Equal(t, `tagflag: error parsing args: arg[0] "-bad": unknown flag: "bad"
+Try 'prog -help' for more information.
+`, buf.String())
+	assert.NotContains(t, buf.String(), "print more")
+
+	// Errors that aren't the user's don't get the hint.
+	buf.Reset()
+	p.writeParseError(&buf, errors.New("disk on fire"))
+	assert.Equal(t, "tagflag: error parsing args: disk on fire\n", buf.String())
+
+	// Explicit help still gets the full usage.
+	assert.Equal(t, ErrDefaultHelp, p.Parse([]string{"-help"}))
+	assert.Contains(t, usageString(t, &cmd), "print more")
+
+	for _, opts := range [][]parseOpt{
+		{Program("prog")},
+		{Program("prog"), ShortErrorUsage(), NoDefaultHelp()},
+	} {
+		p, err := NewParser(&cmd, opts...)
+		require.NoError(t, err)
+		err = p.Parse([]string{"-bad"})
+		buf.Reset()
+		p.writeParseError(&buf, err)
+		assert.Equal(t, "tagflag: error parsing args: arg[0] \"-bad\": unknown flag: \"bad\"\n", buf.String())
+	}
+}
+
+func TestWrapDescription(t *testing.T) {
+	var cmd struct{}
+	desc := "Copies files from one place to another, preserving their modes and times.\n\n" +
+		"Directories are copied recursively. Existing files are replaced.\n" +
+		"  - a list item that's long enough to wrap\n" +
+		"  - short"
+	assert.Equal(t, `Usage:
+  prog
+
+Copies files from one place to
+another, preserving their modes and
+times.
+
+Directories are copied recursively.
+Existing files are replaced.
+  - a list item that's long enough
+  to wrap
+  - short
+
+`, usageString(t, &cmd, Program("prog"), Description(desc), UsageWidth(36)))
+	defer setenv("COLUMNS", "40")()
+	assert.Contains(t, usageString(t, &cmd, Description(desc)), "\nCopies files from one place to another,\npreserving")
+	assert.Contains(t, usageString(t, &cmd, Description(desc), UsageWidth(1000)), "\n"+desc+"\n")
+}
+
+// A type registered with RegisterMarshaler, that doesn't implement fmt.Stringer.
+type testTemperature struct {
+	milliKelvin int64
+}
+
+func init() {
+	RegisterMarshaler(func(s string) (testTemperature, error) {
+		c, err := strconv.ParseFloat(strings.TrimSuffix(s, "C"), 64)
+		return testTemperature{int64((c + 273.15) * 1000)}, err
+	}, true, func(v reflect.Value) string {
+		t := v.Interface().(testTemperature)
+		return strconv.FormatFloat(float64(t.milliKelvin)/1000-273.15, 'f', 1, 64) + "C"
+	})
+}
+
+func TestRegisterMarshalerFormat(t *testing.T) {
+	cmd := struct {
+		Target testTemperature `help:"target temperature"`
+	}{Target: testTemperature{293150}}
+	assert.Contains(t, usageString(t, &cmd), "target temperature (Default: 20.0C)")
+	require.NoError(t, ParseErr(&cmd, []string{"-target=-5C"}))
+	assert.EqualValues(t, 268150, cmd.Target.milliKelvin)
+	assert.Panics(t, func() { RegisterMarshaler(func(int) testTemperature { return testTemperature{} }, true, nil) })
+	assert.Panics(t, func() {
+		RegisterMarshaler(func(string) (testTemperature, bool) { return testTemperature{}, true }, true, nil)
+	})
+}